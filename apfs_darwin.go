@@ -0,0 +1,28 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// volumeSnapshot takes an APFS local snapshot with tmutil. That covers the
+// volumes Time Machine backs up, normally including the one holding dest;
+// snapshots of other volumes need an entitlement rift doesn't have. macOS
+// removes local snapshots by itself after a day, or sooner when space runs
+// low. It returns the snapshot's date,
+// which names it in tmutil listlocalsnapshots.
+func volumeSnapshot(dest string) (string, error) {
+	out, err := exec.Command("tmutil", "localsnapshot").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("tmutil localsnapshot: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	// "Created local snapshot with date: 2024-05-01-101530"
+	text := strings.TrimSpace(string(out))
+	if _, date, ok := strings.Cut(text, "date: "); ok {
+		return date, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+
+package main
+
+import "fmt"
+
+// volumeSnapshot is only available on macOS.
+func volumeSnapshot(dest string) (string, error) {
+	return "", fmt.Errorf("--apfs-snapshot is only available on macOS")
+}
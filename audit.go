@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// auditLog appends one JSON line per destructive operation to a file, so
+// that any deletion or overwrite can be traced back to the run that did it.
+// A nil *auditLog records nothing.
+type auditLog struct {
+	file  *os.File
+	enc   *json.Encoder
+	runID string
+}
+
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	RunID   string    `json:"run_id"`
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Reason  string    `json:"reason"`
+}
+
+// openAuditLog opens path for appending, or returns nil if path is empty.
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file, enc: json.NewEncoder(file), runID: newRunID()}, nil
+}
+
+// newRunID returns a random identifier shared by all records of one run.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// record logs op on path before it happens. Directories are recorded file
+// by file; paths that don't exist are skipped.
+func (a *auditLog) record(op, path, reason string) error {
+	if a == nil {
+		return nil
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return a.enc.Encode(auditRecord{
+			Time:    time.Now().UTC(),
+			RunID:   a.runID,
+			Op:      op,
+			Path:    p,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			Reason:  reason,
+		})
+	})
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncAuditLog(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	if err := os.WriteFile(filepath.Join(srcDir, "keep.txt"), []byte("new contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "keep.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(destDir, "stale"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "stale", "orphan.txt"), []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two runs append to the same log
+	for i := 0; i < 2; i++ {
+		if _, err := syncTree(srcDir, destDir, nil, syncOptions{AuditLog: auditPath}); err != nil {
+			t.Fatalf("syncTree() error = %v", err)
+		}
+	}
+
+	file, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+
+	expected := []auditRecord{
+		{Op: "overwrite", Path: filepath.Join(destDir, "keep.txt"), Size: 3, Reason: "changed in source"},
+		{Op: "delete", Path: filepath.Join(destDir, "stale", "orphan.txt"), Size: 6, Reason: "not in source"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("got %d audit records %+v, want %d", len(records), records, len(expected))
+	}
+	for i, want := range expected {
+		got := records[i]
+		if got.Op != want.Op || got.Path != want.Path || got.Size != want.Size || got.Reason != want.Reason {
+			t.Errorf("records[%d] = %+v, want %+v", i, got, want)
+		}
+		if got.RunID == "" {
+			t.Errorf("records[%d] has no run ID", i)
+		}
+	}
+	if records[0].RunID != records[1].RunID {
+		t.Error("records from one run should share a run ID")
+	}
+}
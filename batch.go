@@ -0,0 +1,367 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// batchPlanName is the entry of a batch holding its plan, ahead of the
+// files it copies, which are stored under batchFilesDir.
+const (
+	batchPlanName = "batch.json"
+	batchFilesDir = "files/"
+)
+
+// writeBatch records the changes a sync of dir would make to fullDest,
+// judged against what rift last wrote there rather than the destination
+// itself, which may be out of reach: files not in the index or modified
+// since the last sync are copied, and indexed paths the source no longer
+// has are deleted. The plan and the files it copies go to batchPath as a
+// tar that --read-batch applies. The state is then recorded as if the
+// sync had happened, so the next batch starts from this one.
+func writeBatch(dir, srcPath, fullDest, batchPath string, parsed *syncArgs) (*syncPlan, error) {
+	started := time.Now()
+	last, err := loadState(fullDest)
+	if err != nil || last.Link {
+		last = &targetState{}
+	}
+
+	// Planning against an empty directory lists everything the source
+	// has, with transforms and sub-paths already applied
+	empty, err := os.MkdirTemp("", "rift-batch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(empty)
+	opts := parsed.opts
+	opts.index = nil
+	full, err := buildPlan(dir, filepath.Join(empty, "dest"), parsed.rules(dir), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &syncPlan{Src: srcPath, Root: dir, Dest: fullDest, Files: full.Files, IDs: full.IDs}
+	plan.Ops = batchOps(full, last, opts.Paths)
+
+	file, err := os.Create(batchPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBatchFile(file, plan, parsed); err != nil {
+		file.Close()
+		os.Remove(batchPath)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	state := &targetState{Source: srcPath, Dest: fullDest, Excludes: parsed.excludePatterns, Presets: parsed.presets, Includes: parsed.includes, Options: parsed.opts, Files: plan.Files, IDs: plan.IDs, TransformKey: transformKey(parsed.opts)}
+	state.mergeSubPaths(parsed.opts.Paths)
+	// Changes made while the batch was written belong in the next one
+	state.LastSync = started
+	if err := saveState(state); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: recording sync state: %v\n", err)
+	}
+	return plan, nil
+}
+
+// batchOps picks the operations of full, a plan of the whole source, that
+// bring a destination matching last up to date, followed by deletes for
+// the paths last recorded that are gone. A file that changed without its
+// modification time moving past the last sync is missed.
+func batchOps(full *syncPlan, last *targetState, subPaths []string) []operation {
+	known := make(map[string]bool, len(last.Files))
+	for _, relPath := range last.Files {
+		known[relPath] = true
+	}
+
+	var ops []operation
+	copied := make(map[string]bool)
+	for _, op := range full.Ops {
+		switch op.Kind {
+		case opMkdir:
+			if known[op.Path] {
+				continue
+			}
+		case opCopy:
+			op.New = !known[op.Path]
+			if !op.New {
+				info, err := os.Stat(filepath.Join(full.Root, op.source()))
+				if err == nil && !info.ModTime().After(last.LastSync) {
+					continue
+				}
+			}
+			copied[op.Path] = true
+		case opLink:
+			op.New = !known[op.Path]
+			if !op.New && !copied[op.Target] {
+				continue
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	valid := make(map[string]bool, len(full.Files))
+	for _, relPath := range full.Files {
+		valid[relPath] = true
+	}
+	var deletes []operation
+	removed := make(map[string]bool)
+	for _, relPath := range last.Files {
+		// The index lists directories before their contents, which go
+		// with them
+		if removed[filepath.Dir(relPath)] {
+			removed[relPath] = true
+			continue
+		}
+		if valid[relPath] || !underSubPaths(relPath, subPaths) {
+			continue
+		}
+		removed[relPath] = true
+		deletes = append(deletes, operation{Kind: opDelete, Path: relPath})
+	}
+	return append(ops, deletes...)
+}
+
+// underSubPaths reports whether relPath is inside one of the sub-paths a
+// sync is limited to, or whether the sync covers the whole tree.
+func underSubPaths(relPath string, subPaths []string) bool {
+	if len(subPaths) == 0 {
+		return true
+	}
+	for _, p := range subPaths {
+		if relPath == p || strings.HasPrefix(relPath, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBatchFile writes plan as a tar: the plan in the JSON form rift plan
+// uses, then the source file of every copy, already passed through its
+// transforms, which the reading side doesn't run.
+func writeBatchFile(w io.Writer, plan *syncPlan, parsed *syncArgs) error {
+	var pf bytes.Buffer
+	if err := writePlanJSON(&pf, plan, parsed); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{Name: batchPlanName, Mode: 0644, Size: int64(pf.Len()), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := pf.WriteTo(tw); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for _, op := range plan.Ops {
+		if op.Kind != opCopy || written[op.source()] {
+			continue
+		}
+		written[op.source()] = true
+		if err := addBatchFile(tw, plan.Root, op.source(), transformsFor(op.source(), parsed.opts)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// addBatchFile adds the source file relPath to tw. With transforms, their
+// output goes through a temporary file first, since the entry's size has
+// to be known before its content.
+func addBatchFile(tw *tar.Writer, root, relPath string, chain []transform) error {
+	file, err := os.Open(filepath.Join(root, relPath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	content, size := io.Reader(file), info.Size()
+	if len(chain) > 0 {
+		tmp, err := os.CreateTemp("", "rift-batch-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if err := copyTransformed(tmp, file, chain); err != nil {
+			return fmt.Errorf("%s: %w", relPath, err)
+		}
+		if size, err = tmp.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		content = tmp
+	}
+	hdr := &tar.Header{Name: batchFilesDir + filepath.ToSlash(relPath), Mode: int64(info.Mode().Perm()), Size: size, ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, content, size); err != nil {
+		return fmt.Errorf("%s: %w", relPath, err)
+	}
+	return nil
+}
+
+// readBatch applies a batch written by --write-batch to the destination
+// given by --to, in a folder named after the one the batch was written
+// for unless --name says otherwise. The batch is unpacked to a temporary
+// directory that stands in for the source.
+func readBatch(parsed *syncArgs) error {
+	file, err := os.Open(parsed.readBatch)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	root, err := os.MkdirTemp("", "rift-batch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+	pf, err := unpackBatch(file, root)
+	if err != nil {
+		return fmt.Errorf("reading batch: %w", err)
+	}
+
+	name := parsed.projectName
+	if name == "" {
+		name = filepath.Base(pf.Dest)
+	}
+	dest, err := filepath.Abs(filepath.Join(parsed.destPath, name))
+	if err != nil {
+		return err
+	}
+	plan := pf.plan()
+	plan.Root, plan.Dest, plan.Stats.Dest = root, dest, dest
+	for _, relPath := range plan.Files {
+		if _, err := os.Lstat(filepath.Join(dest, relPath)); err == nil {
+			plan.Existing++
+		}
+	}
+	opts := batchOptions(pf.Options, parsed.opts)
+	if opts.Background {
+		enterBackground()
+	}
+	if err := checkInterrupted(dest, parsed.resume); err != nil {
+		return err
+	}
+	if err := checkDelta(plan, opts.MaxDelta, parsed.yes); err != nil {
+		return err
+	}
+	if !parsed.yes {
+		if err := confirmDeletes(plan); err != nil {
+			return err
+		}
+	}
+	return executePlan(plan, opts)
+}
+
+// batchOptions returns the options a batch written with opts is applied
+// with. Whoever wrote the batch must not get to run commands or write
+// files on the receiving machine, so transforms, which were applied when
+// the batch was written, and the filter plugin are dropped, and the audit
+// log is the receiver's own. Flags given to --read-batch about how the
+// changes are written add to those of the batch.
+func batchOptions(opts, local syncOptions) syncOptions {
+	opts.Transforms, opts.EOL, opts.Filter = nil, "", ""
+	opts.AuditLog = local.AuditLog
+	opts.Itemize = local.Itemize
+	opts.KeepUndo = opts.KeepUndo || local.KeepUndo
+	opts.Fsync = opts.Fsync || local.Fsync
+	opts.Verify = opts.Verify || local.Verify
+	opts.Manifest = opts.Manifest || local.Manifest
+	opts.Background = opts.Background || local.Background
+	opts.DirectIO = opts.DirectIO || local.DirectIO
+	opts.PortableCopy = opts.PortableCopy || local.PortableCopy
+	if local.Hash != "" {
+		opts.Hash = local.Hash
+	}
+	if local.BwLimit > 0 {
+		opts.BwLimit = local.BwLimit
+	}
+	if local.MaxDelta > 0 {
+		opts.MaxDelta = local.MaxDelta
+	}
+	return opts
+}
+
+// unpackBatch extracts the files of a batch below root and returns its
+// plan, rejecting entries that would land outside root.
+func unpackBatch(r io.Reader, root string) (*planFile, error) {
+	var pf *planFile
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == batchPlanName {
+			if pf, err = readPlanJSON(tr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		relPath, ok := strings.CutPrefix(hdr.Name, batchFilesDir)
+		if !ok || hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(relPath)) {
+			return nil, fmt.Errorf("unexpected entry %q", hdr.Name)
+		}
+		path := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := extractBatchFile(tr, path, hdr); err != nil {
+			return nil, err
+		}
+	}
+	if pf == nil {
+		return nil, fmt.Errorf("no %s in batch", batchPlanName)
+	}
+
+	// Every copy needs its file; a truncated batch must not half-apply
+	var missing []string
+	for _, op := range pf.Operations {
+		if op.Kind != opCopy {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, op.source())); err != nil {
+			missing = append(missing, filepath.ToSlash(op.source()))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("batch is missing %d files, starting with %s", len(missing), missing[0])
+	}
+	return pf, nil
+}
+
+func extractBatchFile(r io.Reader, path string, hdr *tar.Header) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+}
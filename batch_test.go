@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	airGapped := t.TempDir()
+	batchPath := filepath.Join(t.TempDir(), "changes.tar")
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	files := map[string]string{
+		"keep.txt":   "unchanged",
+		"edit.txt":   "old",
+		"gone/a.txt": "removed later",
+		"gone/b.txt": "removed later",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both sides start from the same full batch
+	if err := run([]string{"--to", destDir, "--name", "App", "--write-batch", batchPath}); err != nil {
+		t.Fatalf("first --write-batch error = %v", err)
+	}
+	if err := run([]string{"--to", airGapped, "--read-batch", batchPath, "--yes"}); err != nil {
+		t.Fatalf("first --read-batch error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "App")); err == nil {
+		t.Error("--write-batch should not touch the destination")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(srcDir, "edit.txt"), []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(srcDir, "edit.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(srcDir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "new.txt"), []byte("added"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"--to", destDir, "--name", "App", "--write-batch", batchPath}); err != nil {
+		t.Fatalf("second --write-batch error = %v", err)
+	}
+	root := t.TempDir()
+	file, err := os.Open(batchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := unpackBatch(file, root)
+	file.Close()
+	if err != nil {
+		t.Fatalf("unpackBatch() error = %v", err)
+	}
+	// keep.txt is neither copied nor carried in the batch
+	want := map[string]opKind{"edit.txt": opCopy, "sub": opMkdir, filepath.Join("sub", "new.txt"): opCopy, "gone": opDelete}
+	if len(pf.Operations) != len(want) {
+		t.Errorf("got operations %+v, want %v", pf.Operations, want)
+	}
+	for _, op := range pf.Operations {
+		if want[op.Path] != op.Kind {
+			t.Errorf("unexpected operation %+v", op)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "keep.txt")); err == nil {
+		t.Error("unchanged keep.txt should not be in the batch")
+	}
+
+	if err := run([]string{"--to", airGapped, "--read-batch", batchPath, "--yes"}); err != nil {
+		t.Fatalf("second --read-batch error = %v", err)
+	}
+	app := filepath.Join(airGapped, "App")
+	for name, content := range map[string]string{"keep.txt": "unchanged", "edit.txt": "new content", "sub/new.txt": "added"} {
+		got, err := os.ReadFile(filepath.Join(app, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(app, "gone")); err == nil {
+		t.Error("gone/ should be deleted")
+	}
+}
+
+func TestBatchOptionsFromWriter(t *testing.T) {
+	srcDir := t.TempDir()
+	airGapped := t.TempDir()
+	batchPath := filepath.Join(t.TempDir(), "changes.tar")
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "app.env"), []byte("mode=${RIFT_BATCH_MODE}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RIFT_BATCH_MODE", "written")
+	if err := run([]string{"--to", t.TempDir(), "--name", "App", "--transform", "*.env=env", "--audit-log", auditPath, "--write-batch", batchPath}); err != nil {
+		t.Fatalf("--write-batch error = %v", err)
+	}
+
+	// The batch carries transformed content; the reader runs no transforms
+	// and keeps no audit log it wasn't asked for
+	t.Setenv("RIFT_BATCH_MODE", "read")
+	if err := run([]string{"--to", airGapped, "--read-batch", batchPath, "--yes"}); err != nil {
+		t.Fatalf("--read-batch error = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(airGapped, "App", "app.env")); err != nil || string(got) != "mode=written\n" {
+		t.Errorf("app.env = %q, %v; want %q", got, err, "mode=written\n")
+	}
+	if _, err := os.Stat(auditPath); !os.IsNotExist(err) {
+		t.Errorf("audit log named by the batch was written: %v", err)
+	}
+}
+
+func TestReadBatchReceiverOptions(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	airGapped := t.TempDir()
+	batchPath := filepath.Join(t.TempDir(), "changes.tar")
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	names := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTo := t.TempDir()
+	if err := run([]string{"--to", writeTo, "--name", "App", "--write-batch", batchPath}); err != nil {
+		t.Fatalf("first --write-batch error = %v", err)
+	}
+	if err := run([]string{"--to", airGapped, "--read-batch", batchPath, "--yes"}); err != nil {
+		t.Fatalf("first --read-batch error = %v", err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	for _, name := range names {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := run([]string{"--to", writeTo, "--name", "App", "--write-batch", batchPath}); err != nil {
+		t.Fatalf("second --write-batch error = %v", err)
+	}
+
+	// The batch was written without --max-delta, but the reader's applies
+	app := filepath.Join(airGapped, "App")
+	if err := run([]string{"--to", airGapped, "--read-batch", batchPath, "--max-delta", "50"}); err == nil {
+		t.Error("--read-batch rewriting every file should fail --max-delta 50")
+	}
+	if got, _ := os.ReadFile(filepath.Join(app, "a.txt")); string(got) != "old" {
+		t.Errorf("a.txt = %q after a refused batch, want %q", got, "old")
+	}
+
+	// As does --keep-undo
+	if err := run([]string{"--to", airGapped, "--read-batch", batchPath, "--keep-undo", "--yes"}); err != nil {
+		t.Fatalf("--read-batch --keep-undo error = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(app, "a.txt")); string(got) != "new" {
+		t.Errorf("a.txt = %q, want %q", got, "new")
+	}
+	if err := undoLastRun(app); err != nil {
+		t.Fatalf("undoLastRun() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(app, "a.txt")); string(got) != "old" {
+		t.Errorf("a.txt = %q after undo, want %q", got, "old")
+	}
+}
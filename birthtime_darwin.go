@@ -0,0 +1,23 @@
+//go:build darwin
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+)
+
+// copyBirthTime gives dest the creation time recorded in info. macOS moves
+// a file's creation time back whenever its modification time is set to
+// something earlier, so setting both times to the creation time does it;
+// the caller sets the real modification time afterwards.
+func copyBirthTime(dest *os.File, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	birth := time.Unix(st.Birthtimespec.Unix())
+	return os.Chtimes(dest.Name(), birth, birth)
+}
@@ -0,0 +1,43 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyBirthTime(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	dest, err := os.Create(filepath.Join(dir, "dest.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dest.Close()
+	if err := copyBirthTime(dest, info); err != nil {
+		t.Fatalf("copyBirthTime() error = %v", err)
+	}
+
+	destInfo, err := os.Stat(dest.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Unix(info.Sys().(*syscall.Stat_t).Birthtimespec.Unix())
+	got := time.Unix(destInfo.Sys().(*syscall.Stat_t).Birthtimespec.Unix())
+	if d := got.Sub(want); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("dest created %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// copyBirthTime does nothing: creation times can't be set on this
+// platform.
+func copyBirthTime(dest *os.File, info fs.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyBirthTime gives dest the creation time recorded in info.
+func copyBirthTime(dest *os.File, info fs.FileInfo) error {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil
+	}
+	// Nil times are left unchanged
+	return syscall.SetFileTime(syscall.Handle(dest.Fd()), &attrs.CreationTime, nil, nil)
+}
@@ -0,0 +1,42 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyBirthTime(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	dest, err := os.Create(filepath.Join(dir, "dest.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dest.Close()
+	if err := copyBirthTime(dest, info); err != nil {
+		t.Fatalf("copyBirthTime() error = %v", err)
+	}
+
+	destInfo, err := os.Stat(dest.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := info.Sys().(*syscall.Win32FileAttributeData).CreationTime
+	if got := destInfo.Sys().(*syscall.Win32FileAttributeData).CreationTime; got != want {
+		t.Errorf("dest created %v, want %v", time.Unix(0, got.Nanoseconds()), time.Unix(0, want.Nanoseconds()))
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// checkBudget reports a sync set of the given size and file count that is
+// over the MaxSize or MaxFiles budget: as an error, or with BudgetWarn as
+// a warning only. A sync limited to sub-paths is checked on its own.
+func (o *syncOptions) checkBudget(bytes int64, files int) error {
+	var over string
+	switch {
+	case o.MaxSize > 0 && bytes > o.MaxSize:
+		over = fmt.Sprintf("%d bytes to sync, over the --max-size budget of %d", bytes, o.MaxSize)
+	case o.MaxFiles > 0 && files > o.MaxFiles:
+		over = fmt.Sprintf("%d files to sync, over the --max-files budget of %d", files, o.MaxFiles)
+	default:
+		return nil
+	}
+	if o.BudgetWarn {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", over)
+		return nil
+	}
+	return errors.New(over)
+}
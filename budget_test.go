@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildPlanBudget(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	for name, content := range map[string]string{"a.txt": "12345", "b.txt": "67890"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		opts    syncOptions
+		wantErr bool
+	}{
+		{syncOptions{MaxSize: 10, MaxFiles: 2}, false},
+		{syncOptions{MaxSize: 9}, true},
+		{syncOptions{MaxFiles: 1}, true},
+		{syncOptions{MaxFiles: 1, BudgetWarn: true}, false},
+	} {
+		_, err := buildPlan(srcDir, destDir, nil, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("buildPlan(%+v) error = %v, wantErr %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
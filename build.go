@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// shellOutput receives the output of build and on-change commands. --cron
+// collects it into its report instead.
+var shellOutput io.Writer = os.Stdout
+
+// runBuild runs command in dir so a failed build stops the sync before
+// anything is copied.
+func runBuild(dir, command string) error {
+	if err := runShell(dir, command, nil); err != nil {
+		return fmt.Errorf("build command %q failed: %w; nothing was synced", command, err)
+	}
+	return nil
+}
+
+// runOnChange runs the --on-change command after a sync that changed the
+// destination, e.g. to reload a service. The destination and the number of
+// changes are passed as RIFT_DEST and RIFT_CHANGES.
+func runOnChange(dir, command string, plan *syncPlan) error {
+	env := []string{
+		"RIFT_DEST=" + plan.Dest,
+		"RIFT_CHANGES=" + strconv.Itoa(len(plan.Ops)),
+	}
+	if err := runShell(dir, command, env); err != nil {
+		return fmt.Errorf("on-change command %q failed: %w", command, err)
+	}
+	return nil
+}
+
+// runShell runs command through the platform shell in dir with extra
+// environment variables, passing its output through.
+func runShell(dir, command string, env []string) error {
+	cmd := shellCommand(command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = shellOutput
+	cmd.Stderr = shellOutput
+	return cmd.Run()
+}
+
+// shellCommand prepares command to run through the platform shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRunBuildThenSync(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build command uses sh syntax")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "main.ts"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	err = run([]string{"--to", destDir, "--name", "app", "--build", "mkdir -p dist && cp main.ts dist/main.js", "--from", "dist"})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "app", "main.js")); err != nil {
+		t.Error("build output main.js should be synced")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "app", "main.ts")); err == nil {
+		t.Error("main.ts is outside --from and should not be synced")
+	}
+}
+
+func TestRunBuildFailureSkipsSync(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build command uses sh syntax")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "main.ts"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "app", "--build", "exit 3"}); err == nil {
+		t.Fatal("expected error when the build fails")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "app")); err == nil {
+		t.Error("nothing should be synced after a failed build")
+	}
+}
+
+func TestRunFromOutsideProject(t *testing.T) {
+	if err := run([]string{"--to", "/tmp", "--from", "../elsewhere"}); err == nil {
+		t.Error("expected error for --from outside the project")
+	}
+}
+
+func TestRunOnChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command uses sh syntax")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "changes")
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	args := []string{"--to", destDir, "--on-change", `printf "$RIFT_CHANGES" > ` + marker}
+	if err := run(args); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	got, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("on-change command should run after a change: %v", err)
+	}
+	if string(got) != "1" {
+		t.Errorf("RIFT_CHANGES = %q, want %q", got, "1")
+	}
+
+	// Nothing changed: the command must not run again
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(args); err != nil {
+		t.Fatalf("second run() error = %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("on-change command should not run when nothing changed")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// hashAlgorithms are the hashes --hash can select.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"crc64":  func() hash.Hash { return crc64.New(crc64Table) },
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// parseHashAlgorithm checks a --hash argument.
+func parseHashAlgorithm(name string) error {
+	if _, ok := hashAlgorithms[name]; !ok {
+		return fmt.Errorf("unknown hash %q (want crc64, sha256 or sha512)", name)
+	}
+	return nil
+}
+
+// checksumHash returns the name of the hash --checksum and --verify
+// compare files with. CRC-64 isn't cryptographic, but it is fast and
+// catches any accidental difference.
+func (o syncOptions) checksumHash() string {
+	if o.Hash != "" {
+		return o.Hash
+	}
+	return "crc64"
+}
+
+// manifestHash returns the name of the hash --manifest records.
+func (o syncOptions) manifestHash() string {
+	if o.Hash != "" {
+		return o.Hash
+	}
+	return "sha256"
+}
+
+// hashWorkers returns how many files are hashed at once: one per CPU, or a
+// single one for --background, so a background sync doesn't load every
+// core.
+func (o syncOptions) hashWorkers() int {
+	if o.Background {
+		return 1
+	}
+	return runtime.NumCPU()
+}
+
+// hashPair is a source file and the destination file it is compared with.
+type hashPair struct {
+	src, dest string
+}
+
+// sameContent reports for each pair whether both files have the same
+// content. The pairs are hashed concurrently by up to workers goroutines.
+// Hashes are looked up in and added to cache, unless it is nil.
+func sameContent(pairs []hashPair, workers int, newHash func() hash.Hash, cache *checksumCache) ([]bool, error) {
+	same := make([]bool, len(pairs))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for w := 0; w < min(workers, len(pairs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				eq, err := compareFiles(pairs[i], newHash, cache)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				same[i] = eq
+			}
+		}()
+	}
+	for i := range pairs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return same, firstErr
+}
+
+// compareFiles hashes both files of p and reports whether they match.
+func compareFiles(p hashPair, newHash func() hash.Hash, cache *checksumCache) (bool, error) {
+	src, err := cache.digest(p.src, newHash)
+	if err != nil {
+		return false, err
+	}
+	dest, err := cache.digest(p.dest, newHash)
+	if err != nil {
+		return false, err
+	}
+	return src == dest, nil
+}
+
+func fileDigest(path string, newHash func() hash.Hash) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// verifyCopies re-reads every file plan copied and checks that it matches
+// its source, for --verify. Transformed files differ from their source by
+// design and are not checked.
+func verifyCopies(plan *syncPlan, opts syncOptions) error {
+	var pairs []hashPair
+	for _, op := range plan.Ops {
+		if op.Kind != opCopy || len(transformsFor(op.source(), opts)) > 0 {
+			continue
+		}
+		pairs = append(pairs, hashPair{src: filepath.Join(plan.Root, op.source()), dest: filepath.Join(plan.Dest, op.Path)})
+	}
+
+	same, err := sameContent(pairs, opts.hashWorkers(), hashAlgorithms[opts.checksumHash()], nil)
+	if err != nil {
+		return fmt.Errorf("verifying copies: %w", err)
+	}
+	for i, ok := range same {
+		if !ok {
+			return fmt.Errorf("verifying copies: %s differs from its source", pairs[i].dest)
+		}
+	}
+	return nil
+}
+
+// checksumCache remembers file hashes by path, size and modification time,
+// so --checksum only rehashes files whose stat data changed. There is one
+// cache per destination, holding the source and destination files of its
+// last comparison. A nil *checksumCache hashes every file.
+type checksumCache struct {
+	path string
+
+	mu  sync.Mutex
+	old map[string]cacheEntry
+	new map[string]cacheEntry
+}
+
+// checksumCacheFile is the on-disk form of a checksumCache.
+type checksumCacheFile struct {
+	Algorithm string                `json:"algorithm"`
+	Files     map[string]cacheEntry `json:"files"`
+}
+
+type cacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"`
+}
+
+// checksumCachePath returns the checksum cache file for dest.
+func checksumCachePath(dest string) (string, error) {
+	path, err := statePath(dest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(filepath.Dir(path)), "checksums", filepath.Base(path)), nil
+}
+
+// loadChecksumCache reads the cache of dest. A missing or unreadable cache,
+// or one for a different algorithm, starts out empty.
+func loadChecksumCache(dest, algorithm string) *checksumCache {
+	c := &checksumCache{old: make(map[string]cacheEntry), new: make(map[string]cacheEntry)}
+	path, err := checksumCachePath(dest)
+	if err != nil {
+		return nil
+	}
+	c.path = path
+
+	var file checksumCacheFile
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &file) == nil && file.Algorithm == algorithm && file.Files != nil {
+		c.old = file.Files
+	}
+	return c
+}
+
+// digest returns the hex-encoded hash of the file at path, from the cache
+// if its size and modification time are unchanged.
+func (c *checksumCache) digest(path string, newHash func() hash.Hash) (string, error) {
+	if c == nil {
+		sum, err := fileDigest(path, newHash)
+		return hex.EncodeToString(sum), err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	entry, ok := c.old[path]
+	c.mu.Unlock()
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		sum, err := fileDigest(path, newHash)
+		if err != nil {
+			return "", err
+		}
+		entry = cacheEntry{Size: info.Size(), ModTime: info.ModTime(), Hash: hex.EncodeToString(sum)}
+	}
+
+	c.mu.Lock()
+	c.new[path] = entry
+	c.mu.Unlock()
+	return entry.Hash, nil
+}
+
+// forget drops path, which is about to be overwritten with content of the
+// same size and modification time.
+func (c *checksumCache) forget(path string) {
+	if c != nil {
+		delete(c.new, path)
+	}
+}
+
+// save writes the hashes looked up since the cache was loaded, dropping
+// the rest.
+func (c *checksumCache) save(algorithm string) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(checksumCacheFile{Algorithm: algorithm, Files: c.new})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildPlanChecksum(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	for name, content := range map[string]string{"same.txt": "same", "edited.txt": "before"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size and modification time, different content
+	edited := filepath.Join(srcDir, "edited.txt")
+	info, err := os.Stat(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(edited, []byte("after!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(edited, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	// Same content, different modification time
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(srcDir, "same.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{Checksum: true})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	if len(plan.Ops) != 1 || plan.Ops[0].Path != "edited.txt" {
+		t.Errorf("checksum plan = %+v, want a single copy of edited.txt", plan.Ops)
+	}
+}
+
+func TestVerifyCopies(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := syncTree(srcDir, destDir, nil, syncOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(destDir, "a.txt"), []byte("jello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCopies(plan, syncOptions{}); err == nil {
+		t.Error("expected an error for a copy that differs from its source")
+	}
+}
+
+func TestParseHashAlgorithm(t *testing.T) {
+	if _, err := parseSyncArgs([]string{"--to", "/tmp", "--hash", "sha256"}); err != nil {
+		t.Errorf("--hash sha256: unexpected error %v", err)
+	}
+	if _, err := parseSyncArgs([]string{"--to", "/tmp", "--hash", "md4"}); err == nil {
+		t.Error("expected error for an unknown hash")
+	}
+}
+
+func TestHashWorkersBackground(t *testing.T) {
+	if got := (syncOptions{Background: true}).hashWorkers(); got != 1 {
+		t.Errorf("hashWorkers() with --background = %d, want 1", got)
+	}
+	if got := (syncOptions{}).hashWorkers(); got < 1 {
+		t.Errorf("hashWorkers() = %d, want at least 1", got)
+	}
+}
+
+func TestChecksumCache(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildPlan(srcDir, destDir, nil, syncOptions{Checksum: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the destination behind the cache's back: with the same size
+	// and modification time, the cached hash is trusted
+	dest := filepath.Join(destDir, "a.txt")
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dest, []byte("jello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dest, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Ops) != 0 {
+		t.Errorf("cached hashes should be reused, got %+v", plan.Ops)
+	}
+
+	// A different algorithm starts from scratch and sees the change
+	plan, err = buildPlan(srcDir, destDir, nil, syncOptions{Checksum: true, Hash: "sha256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Ops) != 1 {
+		t.Errorf("expected the changed file to be copied, got %+v", plan.Ops)
+	}
+}
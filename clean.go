@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func runClean(args []string) error {
+	var destPath string
+	var projectName string
+	var auditPath string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--to":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--to requires a path argument")
+			}
+			i++
+			destPath = args[i]
+		case "--name":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--name requires a name argument")
+			}
+			i++
+			projectName = args[i]
+		case "--audit-log":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--audit-log requires a path argument")
+			}
+			i++
+			auditPath = args[i]
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+		}
+	}
+
+	if destPath == "" {
+		return fmt.Errorf("--to flag is required")
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+	if projectName == "" {
+		projectName = filepath.Base(srcPath)
+	}
+	fullDest, err := filepath.Abs(filepath.Join(destPath, projectName))
+	if err != nil {
+		return err
+	}
+
+	removed, err := unlinkTree(srcPath, fullDest)
+	if err != nil {
+		return err
+	}
+	if removed {
+		return removeState(fullDest)
+	}
+
+	state, err := loadState(fullDest)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no rift sync recorded for %s; refusing to remove it", fullDest)
+	}
+	if err != nil {
+		return err
+	}
+
+	audit, err := openAuditLog(auditPath)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	defer audit.Close()
+
+	if err := cleanTree(fullDest, state.Files, audit); err != nil {
+		return err
+	}
+	return removeState(fullDest)
+}
+
+// cleanTree removes the recorded files from dest, then any directories left
+// empty, and finally dest itself if nothing else remains. Anything that was
+// added to the destination outside of rift is kept.
+func cleanTree(dest string, files []string, audit *auditLog) error {
+	// Children sort after their parent, so walking backwards empties
+	// directories before they are removed
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		path := filepath.Join(dest, sorted[i])
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			// Non-empty directories hold files rift didn't create
+			_ = os.Remove(path)
+			continue
+		}
+		if err := audit.record("delete", path, "rift clean"); err != nil {
+			return fmt.Errorf("writing audit log: %w", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+	}
+
+	_ = os.Remove(dest)
+	return nil
+}
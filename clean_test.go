@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCleanRemovesSyncedFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "App"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if err := run([]string{"clean", "--to", destDir, "--name", "App"}); err != nil {
+		t.Fatalf("clean error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "App")); err == nil {
+		t.Error("destination should have been removed")
+	}
+	if _, err := loadState(filepath.Join(destDir, "App")); !os.IsNotExist(err) {
+		t.Errorf("state should have been removed, got error %v", err)
+	}
+
+	// A second clean has nothing to go on
+	if err := run([]string{"clean", "--to", destDir, "--name", "App"}); err == nil {
+		t.Error("expected error when cleaning an unknown destination")
+	}
+}
+
+func TestCleanTreeKeepsForeignFiles(t *testing.T) {
+	destDir := filepath.Join(t.TempDir(), "App")
+
+	if err := os.MkdirAll(filepath.Join(destDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", filepath.Join("sub", "b.txt"), filepath.Join("sub", "user.txt")} {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []string{"a.txt", "sub", filepath.Join("sub", "b.txt")}
+	if err := cleanTree(destDir, files, nil); err != nil {
+		t.Fatalf("cleanTree() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); err == nil {
+		t.Error("a.txt should have been removed")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "sub", "b.txt")); err == nil {
+		t.Error("sub/b.txt should have been removed")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "sub", "user.txt")); err != nil {
+		t.Error("sub/user.txt was not created by rift and should be kept")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape sequences for terminal output.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// parseColorMode checks a --color argument.
+func parseColorMode(mode string) error {
+	switch mode {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("unknown color mode: %s (want auto, always or never)", mode)
+}
+
+// useColor decides whether to color output written to f. In auto mode
+// that is when f is a terminal and NO_COLOR is not set.
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the escape sequence code when enabled.
+func colorize(s, code string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return code + s + ansiReset
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+func runContext(args []string) error {
+	output := "-"
+	ignoreFile := ".dockerignore"
+	var excludePatterns []string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--output", "-o":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--output requires a file argument (or - for stdout)")
+			}
+			i++
+			output = args[i]
+		case "--ignore-file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--ignore-file requires a path argument")
+			}
+			i++
+			ignoreFile = args[i]
+		case "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--exclude requires a pattern argument")
+			}
+			i++
+			excludePatterns = append(excludePatterns, args[i])
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			return fmt.Errorf("unknown flag: %s", args[i])
+		}
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+	rules := contextRules(srcPath, ignoreFile, excludePatterns)
+
+	if output == "-" {
+		return writeContext(os.Stdout, srcPath, rules)
+	}
+	tarPath, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	// Don't pack the archive into itself
+	if rel, err := filepath.Rel(srcPath, tarPath); err == nil && filepath.IsLocal(rel) {
+		rules = append(rules, newRules("--output", "/"+filepath.ToSlash(rel))...)
+	}
+	file, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	if err := writeContext(file, srcPath, rules); err != nil {
+		file.Close()
+		os.Remove(tarPath)
+		return err
+	}
+	return file.Close()
+}
+
+// contextRules returns the rules Docker would apply to a build context:
+// only the .dockerignore counts, not .gitignore, and it is fine for it to
+// be missing. The Dockerfile and the .dockerignore are always sent, since
+// the daemon needs them even when they exclude themselves.
+func contextRules(srcPath, ignoreFile string, extra []string) []rule {
+	rules := newRules("default", ".git")
+	path := ignoreFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(srcPath, path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		rules = append(rules, ignoreFileRules(srcPath, ignoreFile)...)
+	} else if ignoreFile != ".dockerignore" {
+		fmt.Fprintf(os.Stderr, "warning: reading ignore file: %v\n", err)
+	}
+	rules = append(rules, newRules("--exclude", extra...)...)
+	for _, name := range []string{"/Dockerfile", "/" + filepath.Base(ignoreFile)} {
+		rules = append(rules, rule{Pattern: name, Source: "default", Include: true})
+	}
+	return rules
+}
+
+// writeContext writes the files below src that rules don't exclude as a
+// tar stream that docker build - accepts. Entries are in lexical order
+// with ownership dropped and times truncated to the second, so the same
+// tree always produces the same bytes.
+func writeContext(w io.Writer, src string, rules []rule) error {
+	tw := tar.NewWriter(w)
+	err := walkSource(src, rules, func(relPath string, info fs.FileInfo) error {
+		if !info.Mode().IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(relPath),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime().Truncate(time.Second),
+		}
+		// Windows has no execute bit; Docker marks everything executable
+		// rather than guess
+		if runtime.GOOS == "windows" {
+			hdr.Mode |= 0111
+		}
+		if info.IsDir() {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			return tw.WriteHeader(hdr)
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(src, relPath))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.CopyN(tw, file, info.Size()); err != nil {
+			return fmt.Errorf("%s: %w", relPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
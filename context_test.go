@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteContext(t *testing.T) {
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"Dockerfile":    "FROM scratch\n",
+		".dockerignore": "*\n!src\n",
+		".gitignore":    "src/gen.go\n",
+		"notes.txt":     "private",
+		"src/main.go":   "package main",
+		"src/gen.go":    "package main",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules := contextRules(srcDir, ".dockerignore", nil)
+	var first, second bytes.Buffer
+	if err := writeContext(&first, srcDir, rules); err != nil {
+		t.Fatalf("writeContext() error = %v", err)
+	}
+	if err := writeContext(&second, srcDir, rules); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("the same tree should produce the same archive")
+	}
+
+	var names []string
+	tr := tar.NewReader(&first)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Uid != 0 || hdr.Uname != "" {
+			t.Errorf("%s: ownership should be dropped", hdr.Name)
+		}
+		names = append(names, hdr.Name)
+	}
+	// .gitignore plays no part; the Dockerfile and .dockerignore are always sent
+	want := []string{".dockerignore", "Dockerfile", "src/", "src/gen.go", "src/main.go"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultMaxDeletes is the number of deletions a --cron run accepts before
+// reporting the run as unusual.
+const defaultMaxDeletes = 100
+
+// checkCron fails a --cron run that succeeded but deleted more than
+// maxDeletes files or copied files that kept changing. runSync applies it
+// before reporting the run, so the reports agree with the exit status.
+func checkCron(plan *syncPlan, maxDeletes int) error {
+	if plan == nil {
+		return nil
+	}
+	if plan.Stats.FilesDeleted > maxDeletes {
+		return fmt.Errorf("%d files deleted, more than --max-deletes %d", plan.Stats.FilesDeleted, maxDeletes)
+	}
+	if len(plan.Stats.FilesUnstable) > 0 {
+		return fmt.Errorf("%d files changed while they were copied: %s", len(plan.Stats.FilesUnstable), strings.Join(plan.Stats.FilesUnstable, ", "))
+	}
+	return nil
+}
+
+// runCron syncs with all output collected into a report that is only
+// written to w when the run fails or deletes more than parsed.maxDeletes
+// files.
+// A quiet run prints nothing, so cron only sends mail when something needs
+// attention.
+func runCron(w io.Writer, parsed *syncArgs) error {
+	var report bytes.Buffer
+	prevLog, prevShell, prevVerbosity := logOutput, shellOutput, verbosity
+	logOutput, shellOutput = &report, &report
+	verbosity = max(verbosity, levelInfo)
+	plan, err := runSync(parsed)
+	logOutput, shellOutput, verbosity = prevLog, prevShell, prevVerbosity
+	if err == nil {
+		return nil
+	}
+
+	if plan != nil {
+		fmt.Fprintln(&report, plan.Stats.summary())
+	}
+	_, _ = report.WriteTo(w)
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCron(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "keep.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	parsed, err := parseSyncArgs([]string{"--to", destDir, "--name", "out", "--cron", "--max-deletes", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A normal run is silent
+	var out bytes.Buffer
+	if err := runCron(&out, parsed); err != nil {
+		t.Fatalf("runCron() error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("quiet run printed:\n%s", out.String())
+	}
+
+	// One deletion is within the threshold
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCron(&out, parsed); err != nil {
+		t.Fatalf("runCron() error = %v", err)
+	}
+	if err := os.Remove(filepath.Join(srcDir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCron(&out, parsed); err != nil {
+		t.Fatalf("runCron() error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("run within --max-deletes printed:\n%s", out.String())
+	}
+
+	// Two deletions exceed it: report and fail
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runCron(&out, parsed); err != nil {
+		t.Fatalf("runCron() error = %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.Remove(filepath.Join(srcDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runCron(&out, parsed); err == nil {
+		t.Error("expected error when deletions exceed --max-deletes")
+	}
+	for _, want := range []string{"delete a.txt", "delete b.txt", "2 deleted"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+	if logOutput != os.Stderr {
+		t.Error("runCron should restore logOutput")
+	}
+}
+
+func TestRunCronReportsChecks(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	var pings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings = append(pings, r.URL.Path)
+	}))
+	defer server.Close()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	parsed, err := parseSyncArgs([]string{"--to", destDir, "--name", "out", "--cron", "--max-deletes", "1", "--ping", server.URL + "/ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := runCron(&out, parsed); err != nil {
+		t.Fatalf("runCron() error = %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.Remove(filepath.Join(srcDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The run the --max-deletes check fails is pinged as a failure
+	if err := runCron(&out, parsed); err == nil {
+		t.Error("expected error when deletions exceed --max-deletes")
+	}
+	if want := []string{"/ping", "/ping/fail"}; strings.Join(pings, " ") != strings.Join(want, " ") {
+		t.Errorf("pings = %q, want %q", pings, want)
+	}
+}
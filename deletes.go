@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// confirmDeletes shows what the plan's deletes will remove, grouped by
+// directory, and at a terminal asks before going ahead. Unattended runs
+// go ahead with the preview in their output.
+func confirmDeletes(plan *syncPlan) error {
+	files, err := summarizeDeletes(logOutput, plan)
+	if err != nil || files == 0 || !interactive() {
+		return err
+	}
+	if !askUser(fmt.Sprintf("Delete %s from %s?", plural(int64(files), "file"), plan.Dest)) {
+		return fmt.Errorf("deletions not confirmed; rerun with --yes to sync anyway")
+	}
+	return nil
+}
+
+// summarizeDeletes writes the number of files and bytes the plan's
+// deletes remove under each directory, then the totals, and returns the
+// number of files. A deleted directory counts everything inside it, so
+// deletes of paths inside it aren't counted again.
+func summarizeDeletes(w io.Writer, plan *syncPlan) (int, error) {
+	deleted := make(map[string]bool)
+	for _, op := range plan.Ops {
+		if op.Kind == opDelete {
+			deleted[op.Path] = true
+		}
+	}
+
+	groups := make(map[string]*usage)
+	var total usage
+	for _, op := range plan.Ops {
+		if op.Kind != opDelete || insideDeleted(op.Path, deleted) {
+			continue
+		}
+		path := filepath.Join(plan.Dest, op.Path)
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		dir := filepath.ToSlash(filepath.Dir(op.Path))
+		if info.IsDir() {
+			dir = filepath.ToSlash(op.Path)
+		}
+		u := groups[dir]
+		if u == nil {
+			u = &usage{}
+			groups[dir] = u
+		}
+
+		err = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			u.Bytes += info.Size()
+			u.Files++
+			total.Bytes += info.Size()
+			total.Files++
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("scanning %s: %w", path, err)
+		}
+	}
+	if total.Files == 0 {
+		return 0, nil
+	}
+
+	dirs := make([]string, 0, len(groups))
+	for dir := range groups {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if groups[dir].Files == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "delete  %s (%s) under %s/\n", plural(int64(groups[dir].Files), "file"), plural(groups[dir].Bytes, "byte"), dir); err != nil {
+			return 0, err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s (%s) to delete from %s\n", plural(int64(total.Files), "file"), plural(total.Bytes, "byte"), plan.Dest)
+	return total.Files, err
+}
+
+// insideDeleted reports whether a parent directory of relPath is in
+// deleted.
+func insideDeleted(relPath string, deleted map[string]bool) bool {
+	for dir := filepath.Dir(relPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if deleted[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// plural returns n followed by noun, with an s unless n is 1.
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
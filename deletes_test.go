@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSummarizeDeletes(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "kept"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(destDir, "kept"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(destDir, "old", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join("old", "a.txt"):           "12345",
+		filepath.Join("old", "nested", "b.txt"): "123",
+		filepath.Join("kept", "stale.txt"):      "12",
+		"top.txt":                               "1",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := summarizeDeletes(&buf, plan)
+	if err != nil {
+		t.Fatalf("summarizeDeletes() error = %v", err)
+	}
+	want := "delete  1 file (1 byte) under ./\n" +
+		"delete  1 file (2 bytes) under kept/\n" +
+		"delete  2 files (8 bytes) under old/\n" +
+		"4 files (11 bytes) to delete from " + destDir + "\n"
+	if n != 4 || buf.String() != want {
+		t.Errorf("summarizeDeletes() = %d, %q, want 4, %q", n, buf.String(), want)
+	}
+}
+
+func TestSummarizeDeletesInsideDeletedDir(t *testing.T) {
+	destDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(destDir, "old", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join("old", "a.txt"), filepath.Join("old", "nested", "b.txt")} {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte("12"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The index lists the directory and its files as orphans of their own
+	plan := &syncPlan{Dest: destDir, Ops: []operation{
+		{Kind: opDelete, Path: "old"},
+		{Kind: opDelete, Path: filepath.Join("old", "a.txt")},
+		{Kind: opDelete, Path: filepath.Join("old", "nested", "b.txt")},
+	}}
+
+	var buf bytes.Buffer
+	n, err := summarizeDeletes(&buf, plan)
+	if err != nil {
+		t.Fatalf("summarizeDeletes() error = %v", err)
+	}
+	want := "delete  2 files (4 bytes) under old/\n" +
+		"2 files (4 bytes) to delete from " + destDir + "\n"
+	if n != 2 || buf.String() != want {
+		t.Errorf("summarizeDeletes() = %d, %q, want 2, %q", n, buf.String(), want)
+	}
+}
+
+func TestConfirmDeletes(t *testing.T) {
+	destDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(destDir, "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan := &syncPlan{Dest: destDir, Ops: []operation{{Kind: opDelete, Path: "old.txt"}}}
+
+	prevOutput, prevInteractive, prevAsk := logOutput, interactive, askUser
+	defer func() { logOutput, interactive, askUser = prevOutput, prevInteractive, prevAsk }()
+	logOutput = &bytes.Buffer{}
+	interactive = func() bool { return true }
+
+	askUser = func(string) bool { return false }
+	if err := confirmDeletes(plan); err == nil {
+		t.Error("expected an error when the deletions are not confirmed")
+	}
+	askUser = func(string) bool { return true }
+	if err := confirmDeletes(plan); err != nil {
+		t.Errorf("confirmDeletes() error = %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// checkDelta refuses a plan that deletes or rewrites more than maxDelta
+// percent of what the destination holds, unless yes is set or the user
+// confirms at a terminal. An empty source left by a failed build would
+// otherwise wipe a working deployment.
+func checkDelta(plan *syncPlan, maxDelta float64, yes bool) error {
+	if maxDelta <= 0 || yes {
+		return nil
+	}
+
+	var deletes, rewrites int
+	for _, op := range plan.Ops {
+		switch {
+		case op.Kind == opDelete:
+			deletes++
+		case (op.Kind == opCopy || op.Kind == opLink) && !op.New:
+			rewrites++
+		}
+	}
+	held := plan.Existing + deletes
+	if held == 0 {
+		return nil
+	}
+	percent := float64(deletes+rewrites) * 100 / float64(held)
+	if percent <= maxDelta {
+		return nil
+	}
+
+	summary := fmt.Sprintf("%s: %d deletes and %d rewrites would change %.0f%% of the destination, more than --max-delta %g%%",
+		plan.Dest, deletes, rewrites, percent, maxDelta)
+	if interactive() && askUser(summary+". Continue?") {
+		return nil
+	}
+	return fmt.Errorf("%s; rerun with --yes to sync anyway", summary)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckDelta(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	// The source lost three of its four files, as after a failed build
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+
+	prevInteractive, prevAsk := interactive, askUser
+	defer func() { interactive, askUser = prevInteractive, prevAsk }()
+	interactive = func() bool { return true }
+	var asked int
+	answer := false
+	askUser = func(string) bool {
+		asked++
+		return answer
+	}
+
+	for _, tt := range []struct {
+		maxDelta float64
+		yes      bool
+		answer   bool
+		wantErr  bool
+		wantAsk  bool
+	}{
+		{0, false, false, false, false},
+		{80, false, false, false, false},
+		{50, false, false, true, true},
+		{50, false, true, false, true},
+		{50, true, false, false, false},
+	} {
+		asked, answer = 0, tt.answer
+		err := checkDelta(plan, tt.maxDelta, tt.yes)
+		if (err != nil) != tt.wantErr || (asked > 0) != tt.wantAsk {
+			t.Errorf("checkDelta(%g, yes=%v, answer=%v) error = %v, asked = %d", tt.maxDelta, tt.yes, tt.answer, err, asked)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func runDu(args []string) error {
+	var excludePatterns []string
+	var presetNames []string
+	var includes []string
+	var ignoreFile string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--exclude requires a pattern argument")
+			}
+			i++
+			excludePatterns = append(excludePatterns, args[i])
+		case "--preset":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--preset requires a name argument")
+			}
+			i++
+			names, err := parsePresets(args[i])
+			if err != nil {
+				return err
+			}
+			presetNames = append(presetNames, names...)
+		case "--include":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--include requires a pattern argument")
+			}
+			i++
+			includes = append(includes, args[i])
+		case "--ignore-file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--ignore-file requires a path argument")
+			}
+			i++
+			ignoreFile = args[i]
+		case "--ignore-case":
+			ignoreCase = true
+		case "--match-case":
+			ignoreCase = false
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+		}
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	return diskUsage(os.Stdout, srcPath, append(loadRules(srcPath, ignoreFile, excludePatterns), selectionRules(presetNames, includes)...))
+}
+
+// usage is the size and file count of part of the sync set.
+type usage struct {
+	Bytes int64
+	Files int
+}
+
+// diskUsage writes the total size and file count of what a sync of src
+// would copy under each top-level directory, largest first, followed by
+// the files at the top level itself and the overall total.
+func diskUsage(w io.Writer, src string, rules []rule) error {
+	dirs := make(map[string]*usage)
+	var top, total usage
+	err := walkSource(src, rules, func(relPath string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		u := &top
+		if first, _, ok := strings.Cut(filepath.ToSlash(relPath), "/"); ok {
+			if dirs[first] == nil {
+				dirs[first] = &usage{}
+			}
+			u = dirs[first]
+		}
+		u.Bytes += info.Size()
+		u.Files++
+		total.Bytes += info.Size()
+		total.Files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if dirs[names[i]].Bytes != dirs[names[j]].Bytes {
+			return dirs[names[i]].Bytes > dirs[names[j]].Bytes
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%12d  %6d files  %s/\n", dirs[name].Bytes, dirs[name].Files, name); err != nil {
+			return err
+		}
+	}
+	if top.Files > 0 {
+		if _, err := fmt.Fprintf(w, "%12d  %6d files  ./ (top level)\n", top.Bytes, top.Files); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "%12d  %6d files  total\n", total.Bytes, total.Files)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	srcDir := t.TempDir()
+
+	for _, dir := range []string{"small", filepath.Join("big", "nested")} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"README":                               "hi",
+		filepath.Join("small", "a.txt"):        "abc",
+		filepath.Join("big", "b.bin"):          "0123456789",
+		filepath.Join("big", "nested", "c.go"): "package c",
+		filepath.Join("big", "debug.log"):      "ignored by the exclude",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := diskUsage(&buf, srcDir, newRules("--exclude", "*.log")); err != nil {
+		t.Fatalf("diskUsage() error = %v", err)
+	}
+	want := "          19       2 files  big/\n" +
+		"           3       1 files  small/\n" +
+		"           2       1 files  ./ (top level)\n" +
+		"          24       4 files  total\n"
+	if got := buf.String(); got != want {
+		t.Errorf("diskUsage() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runExplain(args []string) error {
+	var excludePatterns []string
+	var presetNames []string
+	var includes []string
+	var ignoreFile string
+	var paths []string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--exclude requires a pattern argument")
+			}
+			i++
+			excludePatterns = append(excludePatterns, args[i])
+		case "--preset":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--preset requires a name argument")
+			}
+			i++
+			names, err := parsePresets(args[i])
+			if err != nil {
+				return err
+			}
+			presetNames = append(presetNames, names...)
+		case "--include":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--include requires a pattern argument")
+			}
+			i++
+			includes = append(includes, args[i])
+		case "--ignore-file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--ignore-file requires a path argument")
+			}
+			i++
+			ignoreFile = args[i]
+		case "--ignore-case":
+			ignoreCase = true
+		case "--match-case":
+			ignoreCase = false
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+			paths = append(paths, args[i])
+		}
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("explain requires a path argument")
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	rules := append(loadRules(srcPath, ignoreFile, excludePatterns), selectionRules(presetNames, includes)...)
+	for _, path := range paths {
+		if err := explainPath(os.Stdout, srcPath, path, rules); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainPath writes whether target (relative to src, or absolute) would be
+// synced and, if not, which rule excluded it.
+func explainPath(w io.Writer, src, target string, rules []rule) error {
+	relPath := target
+	if filepath.IsAbs(target) {
+		var err error
+		if relPath, err = filepath.Rel(src, target); err != nil {
+			return err
+		}
+	}
+	relPath = filepath.Clean(relPath)
+	if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is not inside %s", target, src)
+	}
+
+	// Paths that don't exist yet are treated as files unless written with
+	// a trailing slash
+	isDir := strings.HasSuffix(target, "/")
+	if info, err := os.Stat(filepath.Join(src, relPath)); err == nil {
+		isDir = info.IsDir()
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	r, matched := decidingRule(relPath, rules, isDir)
+	if r == nil {
+		if inc := includedBy(relPath, rules, isDir); inc != nil {
+			_, err := fmt.Fprintf(w, "%s: included by %q (%s)\n", relPath, inc.Pattern, inc)
+			return err
+		}
+		_, err := fmt.Fprintf(w, "%s: included (no pattern matches)\n", relPath)
+		return err
+	}
+
+	via := ""
+	if matched != relPath {
+		via = fmt.Sprintf(" via parent directory %s", matched)
+	}
+	_, err := fmt.Fprintf(w, "%s: excluded by %q (%s)%s\n", relPath, r.Pattern, r, via)
+	return err
+}
+
+// decidingRule returns the rule that excludes relPath, either directly or by
+// excluding one of its parent directories (which a sync never descends
+// into unless an --include or exception could match below it), together
+// with the path that matched.
+func decidingRule(relPath string, rules []rule, isDir bool) (*rule, string) {
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := 1; i <= len(parts); i++ {
+		prefix := strings.Join(parts[:i], "/")
+		r := excludedBy(prefix, rules, i < len(parts) || isDir)
+		if r == nil || (i < len(parts) && reincludedBelow(prefix, rules, r)) {
+			continue
+		}
+		return r, prefix
+	}
+	return nil, ""
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExplainPath(t *testing.T) {
+	srcDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "node_modules", "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, ".gitignore"), []byte("# deps\nnode_modules/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := loadRules(srcDir, "", []string{"*.log"})
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"main.go", "main.go: included (no pattern matches)\n"},
+		{"debug.log", "debug.log: excluded by \"*.log\" (--exclude)\n"},
+		{".git", ".git: excluded by \".git\" (default)\n"},
+		{"node_modules", "node_modules: excluded by \"node_modules/\" (.gitignore:2)\n"},
+		{"node_modules/pkg/index.js", "node_modules/pkg/index.js: excluded by \"node_modules/\" (.gitignore:2) via parent directory node_modules\n"},
+		{filepath.Join(srcDir, "src", "app.log"), "src/app.log: excluded by \"*.log\" (--exclude)\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := explainPath(&buf, srcDir, tt.target, rules); err != nil {
+				t.Fatalf("explainPath() error = %v", err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("explainPath(%q) = %q, want %q", tt.target, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExplainPathOutsideSource(t *testing.T) {
+	var buf bytes.Buffer
+	if err := explainPath(&buf, t.TempDir(), "../elsewhere.txt", nil); err == nil {
+		t.Error("expected error for a path outside the source directory")
+	}
+}
+
+func TestRunExplainMissingPath(t *testing.T) {
+	if err := run([]string{"explain"}); err == nil {
+		t.Error("expected error when explain has no path argument")
+	}
+}
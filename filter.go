@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// filterProcess is a --filter plugin: an external command that decides, one
+// file at a time, whether a file is synced and under which path. rift
+// writes a JSON line per file to its stdin:
+//
+//	{"path": "Modules/Foo.lua", "size": 1234}
+//
+// and reads one JSON line back from its stdout:
+//
+//	{"action": "keep"}
+//	{"action": "skip"}
+//	{"action": "rename", "path": "Foo/Foo.lua"}
+//
+// Paths use forward slashes and are relative to the source and destination.
+type filterProcess struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	enc     *json.Encoder
+	out     *bufio.Scanner
+}
+
+type filterRequest struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type filterResponse struct {
+	Action string `json:"action"`
+	Path   string `json:"path,omitempty"`
+}
+
+// startFilter starts command through the shell in dir. An empty command
+// returns a nil filter, which keeps every file.
+func startFilter(command, dir string) (*filterProcess, error) {
+	if command == "" {
+		return nil, nil
+	}
+
+	cmd := shellCommand(command)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting filter %q: %w", command, err)
+	}
+
+	return &filterProcess{
+		command: command,
+		cmd:     cmd,
+		stdin:   stdin,
+		enc:     json.NewEncoder(stdin),
+		out:     bufio.NewScanner(stdout),
+	}, nil
+}
+
+// decide asks the filter about the file at relPath. It returns the path
+// the file should have at the destination, or false to leave it out.
+func (f *filterProcess) decide(relPath string, size int64) (string, bool, error) {
+	if f == nil {
+		return relPath, true, nil
+	}
+
+	if err := f.enc.Encode(filterRequest{Path: filepath.ToSlash(relPath), Size: size}); err != nil {
+		return "", false, fmt.Errorf("filter %q: %w", f.command, err)
+	}
+	if !f.out.Scan() {
+		err := f.out.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", false, fmt.Errorf("filter %q: reading reply for %s: %w", f.command, filepath.ToSlash(relPath), err)
+	}
+
+	var resp filterResponse
+	if err := json.Unmarshal(f.out.Bytes(), &resp); err != nil {
+		return "", false, fmt.Errorf("filter %q: bad reply for %s: %w", f.command, filepath.ToSlash(relPath), err)
+	}
+	switch resp.Action {
+	case "keep":
+		return relPath, true, nil
+	case "skip":
+		return "", false, nil
+	case "rename":
+		path := filepath.FromSlash(resp.Path)
+		if !filepath.IsLocal(path) {
+			return "", false, fmt.Errorf("filter %q: rename of %s to %q is outside the destination", f.command, filepath.ToSlash(relPath), resp.Path)
+		}
+		return path, true, nil
+	}
+	return "", false, fmt.Errorf("filter %q: unknown action %q for %s", f.command, resp.Action, filepath.ToSlash(relPath))
+}
+
+// Close ends the filter's input and waits for it to exit.
+func (f *filterProcess) Close() error {
+	if f == nil {
+		return nil
+	}
+	_ = f.stdin.Close()
+	if err := f.cmd.Wait(); err != nil {
+		return fmt.Errorf("filter %q: %w", f.command, err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testFilter = `while read -r line; do
+  case "$line" in
+    *'.psd"'*) echo '{"action":"skip"}' ;;
+    *'"Modules/Foo.lua"'*) echo '{"action":"rename","path":"Foo/Foo.lua"}' ;;
+    *) echo '{"action":"keep"}' ;;
+  esac
+done`
+
+func TestSyncFilter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("filter uses sh syntax")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"Core.lua":        "core",
+		"Modules/Foo.lua": "foo",
+		"art/icon.psd":    "psd",
+	} {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := syncOptions{Filter: testFilter}
+	if _, err := syncTree(srcDir, destDir, nil, opts); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "Core.lua")); err != nil {
+		t.Error("Core.lua should be kept")
+	}
+	if got, err := os.ReadFile(filepath.Join(destDir, "Foo", "Foo.lua")); err != nil || string(got) != "foo" {
+		t.Errorf("Modules/Foo.lua should be copied to Foo/Foo.lua, got %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Modules", "Foo.lua")); err == nil {
+		t.Error("Modules/Foo.lua should have been renamed")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "art", "icon.psd")); err == nil {
+		t.Error("icon.psd should be skipped")
+	}
+
+	// The renamed file and its new parent must not look like orphans
+	plan, err := buildPlan(srcDir, destDir, nil, opts)
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	if len(plan.Ops) != 0 {
+		t.Errorf("second plan has operations %+v, want none", plan.Ops)
+	}
+}
+
+func TestSyncFilterBadReply(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("filter uses sh syntax")
+	}
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filter := range []string{
+		`read -r line; echo '{"action":"rename","path":"../escape"}'`,
+		`read -r line; echo '{"action":"explode"}'`,
+		`exit 0`,
+	} {
+		if _, err := buildPlan(srcDir, t.TempDir(), nil, syncOptions{Filter: filter}); err == nil {
+			t.Errorf("filter %q: expected error", filter)
+		}
+	}
+}
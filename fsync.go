@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// syncFile flushes the file at path to disk. It is opened for writing,
+// which Windows needs to flush a file.
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// syncWritten flushes a file written by a sync, and the directory entry
+// that names it, for --fsync.
+func syncWritten(path string) error {
+	if err := syncFile(path); err != nil {
+		return fmt.Errorf("flushing %s: %w", path, err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("flushing %s: %w", filepath.Dir(path), err)
+	}
+	return nil
+}
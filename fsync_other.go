@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// syncDir flushes the entries of the directory at path to disk, so files
+// created, renamed or removed in it survive a power loss.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sourceDir returns the directory a sync reads from: srcPath itself, for
+// --ref a temporary export of that revision, or for --vss or --snapshot a
+// snapshot of srcPath, narrowed to the --from subdirectory if given. For --since it
+// also records the changed paths in opts. The returned cleanup function
+// removes any export or snapshot and is always safe to call.
+func sourceDir(srcPath string, opts *syncOptions) (string, func(), error) {
+	if opts.Since != "" {
+		scope, err := changedSince(filepath.Join(srcPath, opts.From), opts.Since, opts.Ref)
+		if err != nil {
+			return "", func() {}, fmt.Errorf("listing changes since %s: %w", opts.Since, err)
+		}
+		opts.scope = scope
+	}
+
+	if opts.Sparse {
+		cone, err := loadSparseCone(filepath.Join(srcPath, opts.From))
+		if err != nil {
+			return "", func() {}, fmt.Errorf("reading sparse-checkout: %w", err)
+		}
+		opts.cone = cone
+	}
+
+	if opts.VSS {
+		dir, cleanup, err := shadowCopy(srcPath)
+		if err != nil {
+			return "", func() {}, fmt.Errorf("creating shadow copy: %w", err)
+		}
+		return filepath.Join(dir, opts.From), cleanup, nil
+	}
+	if opts.Snapshot {
+		dir, cleanup, err := fsSnapshot(srcPath)
+		if err != nil {
+			return "", func() {}, fmt.Errorf("creating snapshot: %w", err)
+		}
+		return filepath.Join(dir, opts.From), cleanup, nil
+	}
+	if opts.Ref == "" {
+		return filepath.Join(srcPath, opts.From), func() {}, nil
+	}
+	dir, err := exportRef(srcPath, opts.Ref)
+	if err != nil {
+		return "", func() {}, fmt.Errorf("exporting %s: %w", opts.Ref, err)
+	}
+	return filepath.Join(dir, opts.From), func() { _ = os.RemoveAll(dir) }, nil
+}
+
+// changeScope limits a sync to a set of changed files. Keys are relative
+// paths; changed files map to true and their parent directories to false.
+type changeScope map[string]bool
+
+// contains reports whether relPath is a changed file or one of their
+// parent directories. A nil scope contains everything.
+func (s changeScope) contains(relPath string) bool {
+	if s == nil {
+		return true
+	}
+	_, ok := s[relPath]
+	return ok
+}
+
+// files returns the changed files in lexical order.
+func (s changeScope) files() []string {
+	var files []string
+	for path, isFile := range s {
+		if isFile {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// sparseCone is the part of a repository a cone-mode sparse checkout
+// materializes: every file at the top level, the files directly inside
+// each parent of a cone directory, and everything below the cone
+// directories. A nil *sparseCone contains everything.
+type sparseCone struct {
+	prefix  string          // Position of the synced directory in the repository
+	dirs    []string        // Cone directories, slash-separated from the root
+	parents map[string]bool // Their ancestors, whose own files are included
+}
+
+// loadSparseCone reads the sparse-checkout cone of the repository dir is
+// in.
+func loadSparseCone(dir string) (*sparseCone, error) {
+	if v, _ := gitOutput(dir, "config", "--bool", "core.sparseCheckout"); v != "true" {
+		return nil, fmt.Errorf("%s is not a sparse checkout", dir)
+	}
+	if v, _ := gitOutput(dir, "config", "--bool", "core.sparseCheckoutCone"); v == "false" {
+		return nil, fmt.Errorf("only cone mode sparse checkouts are supported")
+	}
+	prefix, err := gitOutput(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	list, err := gitOutput(dir, "sparse-checkout", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	cone := &sparseCone{prefix: strings.TrimSuffix(prefix, "/"), parents: map[string]bool{}}
+	for _, d := range strings.Split(list, "\n") {
+		if d = strings.Trim(d, "/"); d == "" {
+			continue
+		}
+		cone.dirs = append(cone.dirs, d)
+		for p := path.Dir(d); p != "."; p = path.Dir(p) {
+			cone.parents[p] = true
+		}
+	}
+	return cone, nil
+}
+
+// contains reports whether relPath, relative to the synced directory, is
+// in the cone. A directory is in it if anything below it may be.
+func (c *sparseCone) contains(relPath string, isDir bool) bool {
+	if c == nil {
+		return true
+	}
+	p := path.Join(c.prefix, filepath.ToSlash(relPath))
+	for _, d := range c.dirs {
+		if p == d || strings.HasPrefix(p, d+"/") || (isDir && strings.HasPrefix(d, p+"/")) {
+			return true
+		}
+	}
+	if isDir {
+		return false
+	}
+	parent := path.Dir(p)
+	return parent == "." || c.parents[parent]
+}
+
+// gitOutput runs git in dir and returns its trimmed standard output.
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// changedSince lists the files below repoDir that differ between since and
+// ref, or the working tree when ref is empty. Renames are listed as both
+// paths, so the old one is deleted.
+func changedSince(repoDir, since, ref string) (changeScope, error) {
+	args := []string{"diff", "--name-only", "--relative", "--no-renames", "--no-ext-diff", "-z", since}
+	if ref != "" {
+		args = append(args, ref)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoDir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	scope := changeScope{}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.FromSlash(name)
+		scope[path] = true
+		for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+			scope[dir] = false
+		}
+	}
+	return scope, nil
+}
+
+// globalExcludesFile returns git's user-wide excludes file as seen from
+// dir: core.excludesFile if configured, otherwise git's default of
+// $XDG_CONFIG_HOME/git/ignore or ~/.config/git/ignore. It returns "" if
+// there is no home directory to look in.
+func globalExcludesFile(dir string) string {
+	cmd := exec.Command("git", "config", "--path", "core.excludesFile")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		if path := strings.TrimSpace(string(out)); path != "" {
+			return path
+		}
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
+// exportRef extracts the tree of ref below repoDir into a new temporary
+// directory using git archive. Files get the commit time as their
+// modification time, so repeated exports of one revision compare equal.
+func exportRef(repoDir, ref string) (dir string, err error) {
+	dir, err = os.MkdirTemp("", "rift-ref-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	cmd := exec.Command("git", "archive", "--format=tar", ref)
+	cmd.Dir = repoDir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	extractErr := extractTar(out, dir)
+	// Drain whatever is left so git doesn't block on a full pipe
+	_, _ = io.Copy(io.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("git archive: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		return "", extractErr
+	}
+	return dir, nil
+}
+
+// extractTar unpacks directories, regular files and symlinks from r into
+// dir, refusing entries that would land outside it.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive entry %q is outside the export", header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chtimes(path, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		}
+	}
+}
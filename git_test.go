@@ -0,0 +1,263 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// initRepo creates a git repository in dir with files committed, skipping
+// the test when git isn't installed.
+func initRepo(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+}
+
+func TestRunRef(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	initRepo(t, srcDir, map[string]string{
+		"main.lua":     "committed",
+		"lib/util.lua": "util",
+	})
+
+	// Dirty the checkout: an edit and an untracked file
+	if err := os.WriteFile(filepath.Join(srcDir, "main.lua"), []byte("uncommitted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "scratch.txt"), []byte("scratch"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "out", "--ref", "HEAD"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "out", "main.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "committed" {
+		t.Errorf("main.lua = %q, want the committed content", got)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "out", "lib", "util.lua")); err != nil {
+		t.Error("lib/util.lua should exist in destination")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "out", "scratch.txt")); err == nil {
+		t.Error("untracked scratch.txt should not be synced")
+	}
+}
+
+func TestExportRefUnknown(t *testing.T) {
+	srcDir := t.TempDir()
+	initRepo(t, srcDir, map[string]string{"a.txt": "a"})
+
+	if _, err := exportRef(srcDir, "no-such-branch"); err == nil {
+		t.Error("expected error for an unknown revision")
+	}
+}
+
+func TestRunSince(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	initRepo(t, srcDir, map[string]string{
+		"changed.txt": "old",
+		"removed.txt": "removed",
+		"same.txt":    "same",
+	})
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "out"}); err != nil {
+		t.Fatalf("initial run() error = %v", err)
+	}
+	out := filepath.Join(destDir, "out")
+
+	// Outside the changed set: neither should be touched
+	if err := os.WriteFile(filepath.Join(out, "extra.txt"), []byte("extra"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "same.txt"), []byte("edited at destination"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(srcDir, "changed.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(srcDir, "removed.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"--to", destDir, "--name", "out", "--since", "HEAD"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(out, "changed.txt")); string(got) != "new" {
+		t.Errorf("changed.txt = %q, want %q", got, "new")
+	}
+	if _, err := os.Stat(filepath.Join(out, "removed.txt")); err == nil {
+		t.Error("removed.txt should have been deleted")
+	}
+	if _, err := os.Stat(filepath.Join(out, "extra.txt")); err != nil {
+		t.Error("extra.txt is outside the changed set and should be kept")
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "same.txt")); string(got) != "edited at destination" {
+		t.Errorf("same.txt = %q, should not have been compared", got)
+	}
+}
+
+func TestLoadRulesGlobalExcludes(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	ignorePath := filepath.Join(configDir, "git", "ignore")
+	if err := os.MkdirAll(filepath.Dir(ignorePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ignorePath, []byte(".DS_Store\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := excludedBy(filepath.Join("sub", ".DS_Store"), loadRules(t.TempDir(), "", nil), false)
+	if r == nil {
+		t.Fatal(".DS_Store should be excluded by the global excludes file")
+	}
+	if r.Source != ignorePath {
+		t.Errorf("rule source = %q, want %q", r.Source, ignorePath)
+	}
+}
+
+func TestSparseCone(t *testing.T) {
+	srcDir := t.TempDir()
+	initRepo(t, srcDir, map[string]string{
+		"top.txt":     "top",
+		"a/x.txt":     "x",
+		"a/b/y.txt":   "y",
+		"a/b/c/z.txt": "z",
+		"d/w.txt":     "w",
+	})
+	cmd := exec.Command("git", "sparse-checkout", "set", "--cone", "a/b")
+	cmd.Dir = srcDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("git sparse-checkout unavailable: %v: %s", err, out)
+	}
+	// A stray file outside the cone
+	if err := os.MkdirAll(filepath.Join(srcDir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "d", "stray.txt"), []byte("stray"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := syncOptions{Sparse: true}
+	dir, cleanup, err := sourceDir(srcDir, &opts)
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("sourceDir() error = %v", err)
+	}
+	plan, err := buildPlan(dir, t.TempDir(), loadRules(dir, "", nil), opts)
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+
+	var got []string
+	for _, f := range plan.Files {
+		got = append(got, filepath.ToSlash(f))
+	}
+	want := []string{"a", "a/b", "a/b/c", "a/b/c/z.txt", "a/b/y.txt", "a/x.txt", "top.txt"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("synced %v, want %v", got, want)
+	}
+}
+
+func TestSparseNotSparse(t *testing.T) {
+	srcDir := t.TempDir()
+	initRepo(t, srcDir, map[string]string{"a.txt": "a"})
+
+	opts := syncOptions{Sparse: true}
+	_, cleanup, err := sourceDir(srcDir, &opts)
+	defer cleanup()
+	if err == nil {
+		t.Error("expected error for --sparse outside a sparse checkout")
+	}
+}
+
+func TestRunSinceRename(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	initRepo(t, srcDir, map[string]string{"old.txt": "content"})
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "out"}); err != nil {
+		t.Fatalf("initial run() error = %v", err)
+	}
+	for _, args := range [][]string{
+		{"mv", "old.txt", "new.txt"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "rename"},
+	} {
+		cmd := exec.Command("git", args...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	if err := run([]string{"--to", destDir, "--name", "out", "--since", "HEAD~1", "-y"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	out := filepath.Join(destDir, "out")
+	if _, err := os.Stat(filepath.Join(out, "new.txt")); err != nil {
+		t.Errorf("new.txt should have been copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "old.txt")); err == nil {
+		t.Error("old.txt was renamed away and should have been deleted")
+	}
+}
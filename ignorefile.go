@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// parseIgnoreFile reads the rules of an --ignore-file in the dialect its
+// name implies: Docker's for .dockerignore (including names like
+// Dockerfile.dockerignore), gitignore's for anything else, such as
+// .npmignore.
+func parseIgnoreFile(path string) ([]rule, error) {
+	if strings.HasSuffix(filepath.Base(path), ".dockerignore") {
+		return parseDockerignore(path)
+	}
+	return parseGitignore(path)
+}
+
+// parseDockerignore reads a .dockerignore. Unlike gitignore, every pattern
+// is relative to the root, so "*.log" only matches at the top level, and
+// "!" exceptions are honoured: they become Negate rules, so as in Docker the
+// last line matching a path decides, and an exception can re-include a path
+// inside an excluded directory.
+func parseDockerignore(ignorePath string) ([]rule, error) {
+	file, err := os.Open(ignorePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []rule
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+
+		// Docker cleans patterns, so "./dist/" and "/dist" mean "dist"
+		line = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(line)), "/")
+		if line == "" {
+			continue
+		}
+		rules = append(rules, rule{Pattern: "/" + line, Source: filepath.Base(ignorePath), Line: lineNum, Negate: negate})
+	}
+	return rules, scanner.Err()
+}
+
+// ignoreFileRules returns the rules of ignoreFile, which is relative to
+// srcPath unless absolute. A missing file is worth a warning: without it
+// nothing the user expected is excluded.
+func ignoreFileRules(srcPath, ignoreFile string) []rule {
+	if !filepath.IsAbs(ignoreFile) {
+		ignoreFile = filepath.Join(srcPath, ignoreFile)
+	}
+	rules, err := parseIgnoreFile(ignoreFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: reading ignore file: %v\n", err)
+	}
+	return rules
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRulesIgnoreFile(t *testing.T) {
+	srcDir := t.TempDir()
+
+	files := map[string]string{
+		".gitignore":    "build/\n",
+		".dockerignore": "# docker\n*.log\n!keep.log\n./dist/\nsecrets\n",
+		".npmignore":    "*.log\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ignoreFile string
+		relPath    string
+		isDir      bool
+		expected   bool
+	}{
+		// Docker patterns are relative to the root
+		{".dockerignore", "debug.log", false, true},
+		{".dockerignore", "sub/debug.log", false, false},
+		{".dockerignore", "keep.log", false, false},
+		{".dockerignore", "dist/app.js", false, true},
+		{".dockerignore", "secrets", true, true},
+		{".dockerignore", "sub/secrets", true, false},
+		// .gitignore no longer applies, .git still does
+		{".dockerignore", "build", true, false},
+		{".dockerignore", ".git", true, true},
+		// .npmignore uses gitignore's rules
+		{".npmignore", "sub/debug.log", false, true},
+		{".npmignore", "build", true, false},
+		{"", "build", true, true},
+	}
+
+	for _, tt := range tests {
+		rules := loadRules(srcDir, tt.ignoreFile, nil)
+		if got := shouldExclude(tt.relPath, rules, tt.isDir); got != tt.expected {
+			t.Errorf("%q: shouldExclude(%q) = %v, want %v", tt.ignoreFile, tt.relPath, got, tt.expected)
+		}
+	}
+}
+
+func TestDockerignoreLastMatchWins(t *testing.T) {
+	srcDir := t.TempDir()
+	content := "*.log\n!keep*.log\nkeep-not.log\n"
+	if err := os.WriteFile(filepath.Join(srcDir, ".dockerignore"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules := loadRules(srcDir, ".dockerignore", nil)
+
+	tests := []struct {
+		relPath  string
+		expected bool
+	}{
+		{"debug.log", true},
+		{"keep.log", false},
+		{"keep-not.log", true},
+	}
+	for _, tt := range tests {
+		if got := shouldExclude(tt.relPath, rules, false); got != tt.expected {
+			t.Errorf("shouldExclude(%q) = %v, want %v", tt.relPath, got, tt.expected)
+		}
+	}
+}
+
+func TestDockerignoreExceptionInExcludedDir(t *testing.T) {
+	srcDir := t.TempDir()
+	tree := map[string]string{
+		".dockerignore":     "dir\nempty\n!dir/keep\n!empty/keep\n",
+		"dir/keep":          "keep",
+		"dir/drop":          "drop",
+		"empty/drop":        "drop",
+		"other/file.txt":    "file",
+		"dir/sub/keep":      "nested",
+		"dir/sub/other.txt": "other",
+	}
+	for name, content := range tree {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := loadRules(srcDir, ".dockerignore", nil)
+
+	var dirs, files []string
+	err := walkSource(srcDir, rules, func(relPath string, info fs.FileInfo) error {
+		if info.IsDir() {
+			dirs = append(dirs, filepath.ToSlash(relPath))
+		} else {
+			files = append(files, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walkSource() error = %v", err)
+	}
+
+	// empty holds nothing re-included, so it isn't reported at all
+	if got, want := strings.Join(dirs, " "), "dir other"; got != want {
+		t.Errorf("dirs = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(files, " "), ".dockerignore dir/keep other/file.txt"; got != want {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
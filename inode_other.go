@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fileID identifies a file independently of its path.
+type fileID struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+// fileIdentity reports no identity: the file index isn't part of the file
+// info on this platform.
+func fileIdentity(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// hardLinkID reports no hard links: the file index isn't part of the file
+// info on this platform.
+func hardLinkID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
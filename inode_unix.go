@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file independently of its path.
+type fileID struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+// fileIdentity returns the device and inode of the file described by info.
+func fileIdentity(info fs.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
+
+// hardLinkID returns the identity of the file described by info if more
+// than one path refers to it.
+func hardLinkID(info fs.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uint64(st.Nlink) < 2 {
+		return fileID{}, false
+	}
+	return fileIdentity(info)
+}
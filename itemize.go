@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// itemizeOutput receives --itemize lines.
+var itemizeOutput io.Writer = os.Stdout
+
+// itemize describes op in one compact line modelled on rsync's
+// --itemize-changes: an update type (> copy, c create, h hard link,
+// * delete), a file type (f or d) and one flag per attribute that differs:
+// s for size, t for modification time, p for permissions, or + for a new
+// file. The destination is compared as it is before op runs.
+//
+//	>f+++ new.txt
+//	>fst. changed.txt
+//	cd+++ newdir/
+//	hf+++ copy.txt => new.txt
+//	*deleting old.txt
+func itemize(plan *syncPlan, op operation) string {
+	path := filepath.ToSlash(op.Path)
+	switch op.Kind {
+	case opMkdir:
+		return "cd+++ " + path + "/"
+	case opDelete:
+		return "*deleting " + path
+	case opLink:
+		if op.New {
+			return "hf+++ " + path + " => " + filepath.ToSlash(op.Target)
+		}
+		return "hf... " + path + " => " + filepath.ToSlash(op.Target)
+	}
+
+	destInfo, err := os.Stat(filepath.Join(plan.Dest, op.Path))
+	if err != nil {
+		return ">f+++ " + path
+	}
+	flags := []byte(">f...")
+	srcInfo, err := os.Stat(filepath.Join(plan.Root, op.source()))
+	if err != nil {
+		return string(flags) + " " + path
+	}
+	if srcInfo.Size() != destInfo.Size() {
+		flags[2] = 's'
+	}
+	if !srcInfo.ModTime().Equal(destInfo.ModTime()) {
+		flags[3] = 't'
+	}
+	if srcInfo.Mode().Perm() != destInfo.Mode().Perm() {
+		flags[4] = 'p'
+	}
+	return string(flags) + " " + path
+}
+
+// printItemized writes the itemized form of every operation in plan, for
+// rift plan --itemize.
+func printItemized(w io.Writer, plan *syncPlan) error {
+	for _, op := range plan.Ops {
+		if _, err := fmt.Fprintln(w, itemize(plan, op)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
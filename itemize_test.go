@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestItemize(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "changed.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(srcDir, "changed.txt"), []byte("longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(srcDir, "changed.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "orphan.txt"), []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	itemizeOutput = &buf
+	defer func() { itemizeOutput = os.Stdout }()
+	if err := executePlan(plan, syncOptions{Itemize: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ">fst. changed.txt\ncd+++ sub/\n>f+++ sub/new.txt\n*deleting orphan.txt\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("itemized output = %q, want %q", got, expected)
+	}
+}
+
+func TestItemizePerms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not tracked on Windows")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "run.sh"), []byte("#!/bin/sh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(srcDir, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &syncPlan{Root: srcDir, Dest: destDir}
+	if got := itemize(plan, operation{Kind: opCopy, Path: "run.sh"}); got != ">f..p run.sh" {
+		t.Errorf("itemize() = %q, want %q", got, ">f..p run.sh")
+	}
+}
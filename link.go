@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// linkTree points dest at src with a symlink (a directory junction on
+// Windows) so the destination always reflects the working tree. An existing
+// link is replaced; anything else at dest is left alone.
+func linkTree(src, dest string) error {
+	if target, ok := readLink(dest); ok {
+		if target == src {
+			return nil // Already linked
+		}
+		if err := os.Remove(dest); err != nil {
+			return fmt.Errorf("removing old link: %w", err)
+		}
+	} else if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists and is not a link; remove it (rift clean) before using --link", dest)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := createLink(src, dest); err != nil {
+		return fmt.Errorf("linking %s: %w", dest, err)
+	}
+	return nil
+}
+
+// unlinkTree removes dest if it is a link to src created by --link,
+// reporting whether there was one. Links pointing elsewhere are left alone.
+func unlinkTree(src, dest string) (bool, error) {
+	if target, ok := readLink(dest); !ok || target != src {
+		return false, nil
+	}
+	if err := os.Remove(dest); err != nil {
+		return false, fmt.Errorf("removing link: %w", err)
+	}
+	return true, nil
+}
+
+// readLink returns the target of dest if it is a symlink or junction.
+func readLink(dest string) (string, bool) {
+	info, err := os.Lstat(dest)
+	if err != nil || !isLink(info) {
+		return "", false
+	}
+	target, err := os.Readlink(dest)
+	if err != nil {
+		return "", false
+	}
+	return target, true
+}
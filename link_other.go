@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+func createLink(src, dest string) error {
+	return os.Symlink(src, dest)
+}
+
+func isLink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunLink(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	// Link twice to make sure relinking is a no-op
+	for i := 0; i < 2; i++ {
+		if err := run([]string{"--to", destDir, "--name", "Linked", "--link"}); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+
+	linkPath := filepath.Join(destDir, "Linked")
+	if target, ok := readLink(linkPath); !ok || target != srcDir {
+		t.Fatalf("readLink(%q) = %q, %v, want %q, true", linkPath, target, ok, srcDir)
+	}
+
+	// Changes in the source are visible immediately
+	if err := os.WriteFile(filepath.Join(srcDir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(linkPath, "new.txt")); err != nil {
+		t.Error("new.txt should be visible through the link")
+	}
+
+	// Clean removes only the link
+	if err := run([]string{"clean", "--to", destDir, "--name", "Linked"}); err != nil {
+		t.Fatalf("clean error = %v", err)
+	}
+	if _, err := os.Lstat(linkPath); err == nil {
+		t.Error("link should have been removed")
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "test.txt")); err != nil {
+		t.Error("source files should be untouched by clean")
+	}
+}
+
+func TestRunCopyReplacesLink(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "App", "--link"}); err != nil {
+		t.Fatalf("run --link error = %v", err)
+	}
+	if err := run([]string{"--to", destDir, "--name", "App"}); err != nil {
+		t.Fatalf("run error = %v", err)
+	}
+
+	destPath := filepath.Join(destDir, "App")
+	if _, ok := readLink(destPath); ok {
+		t.Error("destination should be a real copy after switching back from --link")
+	}
+	if _, err := os.Stat(filepath.Join(destPath, "test.txt")); err != nil {
+		t.Error("test.txt should exist in destination")
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "test.txt")); err != nil {
+		t.Error("source files should be untouched")
+	}
+}
+
+func TestRunLinkRefusesExistingCopy(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(destDir, "App"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "App", "--link"}); err == nil {
+		t.Error("expected error when destination is an existing directory")
+	}
+}
+
+func TestRunCleanRefusesNonLink(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(destDir, "App"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"clean", "--to", destDir, "--name", "App"}); err == nil {
+		t.Error("expected error when destination is not a link")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "App")); err != nil {
+		t.Error("destination directory should not have been removed")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// createLink uses a directory junction rather than a symlink, since junctions
+// don't require administrator rights or developer mode.
+func createLink(src, dest string) error {
+	out, err := exec.Command("cmd", "/c", "mklink", "/J", dest, src).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// isLink also accepts ModeIrregular, which newer Go versions report for
+// junctions.
+func isLink(info os.FileInfo) bool {
+	return info.Mode()&(os.ModeSymlink|os.ModeIrregular) != 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func runList(args []string) error {
+	var excludePatterns []string
+	var presetNames []string
+	var includes []string
+	var ignoreFile string
+	var sizes bool
+	var largest int
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--exclude requires a pattern argument")
+			}
+			i++
+			excludePatterns = append(excludePatterns, args[i])
+		case "--preset":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--preset requires a name argument")
+			}
+			i++
+			names, err := parsePresets(args[i])
+			if err != nil {
+				return err
+			}
+			presetNames = append(presetNames, names...)
+		case "--include":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--include requires a pattern argument")
+			}
+			i++
+			includes = append(includes, args[i])
+		case "--sizes":
+			sizes = true
+		case "--largest":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--largest requires a number argument")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("--largest: invalid number %q", args[i])
+			}
+			largest = n
+		case "--ignore-file":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--ignore-file requires a path argument")
+			}
+			i++
+			ignoreFile = args[i]
+		case "--ignore-case":
+			ignoreCase = true
+		case "--match-case":
+			ignoreCase = false
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+		}
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	rules := append(loadRules(srcPath, ignoreFile, excludePatterns), selectionRules(presetNames, includes)...)
+	if largest > 0 {
+		return listLargest(os.Stdout, srcPath, rules, largest)
+	}
+	return listFiles(os.Stdout, srcPath, rules, sizes)
+}
+
+// listFiles writes every file that a sync of src would copy, one per line,
+// optionally prefixed with its size in bytes.
+func listFiles(w io.Writer, src string, rules []rule, sizes bool) error {
+	return walkSource(src, rules, func(relPath string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		if sizes {
+			_, err := fmt.Fprintf(w, "%12d  %s\n", info.Size(), relPath)
+			return err
+		}
+		_, err := fmt.Fprintln(w, relPath)
+		return err
+	})
+}
+
+// listLargest writes the n largest files that a sync of src would copy,
+// biggest first, each prefixed with its size in bytes.
+func listLargest(w io.Writer, src string, rules []rule, n int) error {
+	type sizedFile struct {
+		path string
+		size int64
+	}
+	var files []sizedFile
+	err := walkSource(src, rules, func(relPath string, info fs.FileInfo) error {
+		if !info.IsDir() {
+			files = append(files, sizedFile{filepath.ToSlash(relPath), info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].size > files[j].size })
+	for _, f := range files[:min(n, len(files))] {
+		if _, err := fmt.Fprintf(w, "%12d  %s\n", f.size, f.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
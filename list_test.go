@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListFiles(t *testing.T) {
+	srcDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.txt":                      "hello",
+		filepath.Join("sub", "b.go"): "package b",
+		"debug.log":                  "logs",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := listFiles(&buf, srcDir, newRules("--exclude", "*.log"), false); err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if got, want := buf.String(), "a.txt\nsub/b.go\n"; got != want {
+		t.Errorf("listFiles() = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := listFiles(&buf, srcDir, newRules("--exclude", "*.log"), true); err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if got, want := buf.String(), "           5  a.txt\n           9  sub/b.go\n"; got != want {
+		t.Errorf("listFiles() with sizes = %q, want %q", got, want)
+	}
+}
+
+func TestListLargest(t *testing.T) {
+	srcDir := t.TempDir()
+
+	files := map[string]string{
+		"small.txt":  "a",
+		"medium.txt": "abc",
+		"large.bin":  "0123456789",
+		"huge.log":   "excluded even though it is the largest",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := listLargest(&buf, srcDir, newRules("--exclude", "*.log"), 2); err != nil {
+		t.Fatalf("listLargest() error = %v", err)
+	}
+	if got, want := buf.String(), "          10  large.bin\n           3  medium.txt\n"; got != want {
+		t.Errorf("listLargest() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Verbosity levels for diagnostic output.
+const (
+	levelInfo  = 1 // -v: every file copied, created or deleted
+	levelDebug = 2 // -vv or --debug-ignore: every exclusion decision
+)
+
+var (
+	verbosity int
+	logOutput io.Writer = os.Stderr
+)
+
+// logf writes a line of diagnostic output if verbosity is at least level.
+func logf(level int, format string, args ...any) {
+	if verbosity >= level {
+		fmt.Fprintf(logOutput, format+"\n", args...)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDebugIgnore(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, ".gitignore"), []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "debug.log"), []byte("logs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	var buf bytes.Buffer
+	logOutput = &buf
+	defer func() { logOutput = os.Stderr }()
+
+	if err := run([]string{"--to", destDir, "--debug-ignore"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		`exclude debug.log (pattern "*.log" from .gitignore:1)`,
+		"include main.go",
+		"copy main.go",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLogfVerbosity(t *testing.T) {
+	var buf bytes.Buffer
+	logOutput = &buf
+	defer func() { logOutput = os.Stderr }()
+	defer func() { verbosity = 0 }()
+
+	verbosity = levelInfo
+	logf(levelInfo, "shown %d", 1)
+	logf(levelDebug, "hidden")
+
+	if got, want := buf.String(), "shown 1\n"; got != want {
+		t.Errorf("log output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// mailSettings say where and when to email a report after a sync.
+type mailSettings struct {
+	to   []string
+	from string
+	smtp string // host:port of the mail server
+	on   string // "always", "error" or "delete"
+}
+
+// mailReport emails a summary of the run to dest when the settings ask
+// for one. The server is logged in to if $RIFT_SMTP_USERNAME is set, with
+// $RIFT_SMTP_PASSWORD. Not being able to send is worth a warning but
+// doesn't fail the sync.
+func mailReport(m mailSettings, dest string, plan *syncPlan, runErr error, elapsed time.Duration) {
+	if len(m.to) == 0 || !reportWanted(m.on, plan, runErr) {
+		return
+	}
+	from := m.from
+	if from == "" {
+		host, _ := os.Hostname()
+		from = "rift@" + host
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("RIFT_SMTP_USERNAME"); user != "" {
+		host, _, _ := net.SplitHostPort(m.smtp)
+		auth = smtp.PlainAuth("", user, os.Getenv("RIFT_SMTP_PASSWORD"), host)
+	}
+	msg := mailMessage(from, m.to, dest, plan, runErr, elapsed)
+	if err := smtp.SendMail(m.smtp, auth, from, m.to, msg); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: mailing report: %v\n", err)
+	}
+}
+
+// mailMessage formats the report as a plain-text email.
+func mailMessage(from string, to []string, dest string, plan *syncPlan, runErr error, elapsed time.Duration) []byte {
+	subject := "rift: synced " + dest
+	if runErr != nil {
+		subject = "rift: sync to " + dest + " failed"
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	if plan != nil {
+		fmt.Fprintf(&b, "%s\r\n", plan.Stats.summary())
+	}
+	fmt.Fprintf(&b, "Took %s.\r\n", elapsed.Round(time.Millisecond))
+	if runErr != nil {
+		fmt.Fprintf(&b, "\r\nerror: %v\r\n", runErr)
+	}
+	if plan != nil && len(plan.Stats.FilesUnstable) > 0 {
+		fmt.Fprintf(&b, "\r\nChanged while they were copied:\r\n")
+		for _, path := range plan.Stats.FilesUnstable {
+			fmt.Fprintf(&b, "  %s\r\n", path)
+		}
+	}
+	return b.Bytes()
+}
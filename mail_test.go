@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailMessage(t *testing.T) {
+	plan := &syncPlan{Stats: syncStats{Source: "/src/app", Dest: "/backup/app", FilesChecked: 10, FilesCopied: 3, BytesCopied: 42, FilesDeleted: 1}}
+	msg := string(mailMessage("rift@host", []string{"a@example.com", "b@example.com"}, "/backup/app", plan, nil, 1500*time.Millisecond))
+
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: rift: synced /backup/app\r\n",
+		"/src/app -> /backup/app: 10 files checked, 3 copied (42 bytes), 1 deleted, 0 directories created\r\n",
+		"Took 1.5s.",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+
+	msg = string(mailMessage("rift@host", []string{"a@example.com"}, "/backup/app", nil, errors.New("disk full"), time.Second))
+	if !strings.Contains(msg, "Subject: rift: sync to /backup/app failed\r\n") || !strings.Contains(msg, "error: disk full") {
+		t.Errorf("failure message should say so:\n%s", msg)
+	}
+}
+
+func TestParseSyncArgsMail(t *testing.T) {
+	parsed, err := parseSyncArgs([]string{"--to", "/tmp", "--mail-to", "a@example.com, b@example.com", "--smtp", "mail.example.com", "--mail-on", "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.mail.to) != 2 || parsed.mail.smtp != "mail.example.com:25" || parsed.mail.on != "error" {
+		t.Errorf("mail settings = %+v", parsed.mail)
+	}
+	if _, err := parseSyncArgs([]string{"--to", "/tmp", "--mail-on", "sometimes"}); err == nil {
+		t.Error("expected error for unknown --mail-on value")
+	}
+}
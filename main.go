@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
@@ -18,237 +22,1670 @@ func main() {
 }
 
 func run(args []string) error {
-	var destPath string
-	var projectName string
-	var excludePatterns []string
+	verbosity = 0
+	ignoreCase = defaultIgnoreCase
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "package":
+			return runPackage(args[1:])
+		case "clean":
+			return runClean(args[1:])
+		case "status":
+			return runStatus(args[1:])
+		case "undo":
+			return runUndo(args[1:])
+		case "list":
+			return runList(args[1:])
+		case "context":
+			return runContext(args[1:])
+		case "du":
+			return runDu(args[1:])
+		case "explain":
+			return runExplain(args[1:])
+		case "plan":
+			return runPlan(args[1:])
+		case "apply":
+			return runApply(args[1:])
+		case "install-service":
+			return runInstallService(args[1:])
+		case "install-agent":
+			return runInstallAgent(args[1:])
+		}
+	}
+
+	parsed, err := parseSyncArgs(args)
+	if err != nil {
+		return err
+	}
+	if parsed.help {
+		printUsage()
+		return nil
+	}
+
+	if parsed.cron {
+		return runCron(os.Stdout, parsed)
+	}
+	_, err = runSync(parsed)
+	return err
+}
+
+// runSync performs the sync (or link) described by parsed. The returned
+// plan is nil for --link and when planning failed.
+func runSync(parsed *syncArgs) (plan *syncPlan, err error) {
+	started := time.Now()
+	defer func() {
+		if err == nil && parsed.cron {
+			err = checkCron(plan, parsed.maxDeletes)
+		}
+		dest := ""
+		if plan != nil {
+			dest = plan.Dest
+		} else if _, fullDest, rerr := parsed.resolve(); rerr == nil {
+			dest = fullDest
+		}
+		mailReport(parsed.mail, dest, plan, err, time.Since(started))
+		notifyWebhooks(parsed.notify, dest, plan, err, time.Since(started))
+		pingHealthcheck(parsed.ping, plan, err)
+	}()
+
+	if parsed.readBatch != "" {
+		return nil, readBatch(parsed)
+	}
+	srcPath, fullDest, err := parsed.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if parsed.opts.Background {
+		enterBackground()
+	}
+	state := &targetState{Source: srcPath, Dest: fullDest, Excludes: parsed.excludePatterns, Presets: parsed.presets, Includes: parsed.includes, Link: parsed.link, Options: parsed.opts}
+
+	if parsed.link {
+		if err := linkTree(srcPath, fullDest); err != nil {
+			return nil, err
+		}
+		recordSync(state)
+		return nil, nil
+	}
+
+	// Switching back from --link: replace the link with a real copy rather
+	// than syncing the source onto itself
+	if _, err := unlinkTree(srcPath, fullDest); err != nil {
+		return nil, err
+	}
+	if err := checkInterrupted(fullDest, parsed.resume); err != nil {
+		return nil, err
+	}
+
+	if parsed.build != "" {
+		if err := runBuild(srcPath, parsed.build); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, cleanup, err := sourceDir(srcPath, &parsed.opts)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	parsed.opts.loadLastSync(fullDest)
+	if parsed.writeBatch != "" {
+		return writeBatch(dir, srcPath, fullDest, parsed.writeBatch, parsed)
+	}
+
+	// Perform sync
+	plan, err = buildPlan(dir, fullDest, parsed.rules(dir), parsed.opts)
+	if err == nil {
+		plan.setSource(srcPath)
+		err = checkDelta(plan, parsed.opts.MaxDelta, parsed.yes)
+		if err == nil && !parsed.yes {
+			err = confirmDeletes(plan)
+		}
+		if err == nil {
+			err = executePlan(plan, parsed.opts)
+		}
+	}
+	if parsed.statsPath != "" {
+		stats := syncStats{Source: srcPath, Dest: fullDest}
+		if plan != nil {
+			stats = plan.Stats
+		}
+		stats.StartedAt = started
+		if serr := writeStats(parsed.statsPath, stats, err); serr != nil {
+			fmt.Fprintf(os.Stderr, "warning: writing stats: %v\n", serr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordPlan(state, plan)
+
+	if parsed.onChange != "" && len(plan.Ops) > 0 {
+		return plan, runOnChange(srcPath, parsed.onChange, plan)
+	}
+	return plan, nil
+}
+
+// enterBackground lowers the process priority for --background. Not being
+// able to is worth a warning but not worth failing the sync.
+func enterBackground() {
+	if err := lowerPriority(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: lowering priority: %v\n", err)
+	}
+}
+
+// syncArgs holds the flags shared by a sync and rift plan.
+type syncArgs struct {
+	destPath        string
+	projectName     string
+	excludePatterns []string
+	presets         []string
+	includes        []string
+	link            bool
+	opts            syncOptions
+	statsPath       string
+	build           string
+	onChange        string
+	cron            bool
+	maxDeletes      int
+	yes             bool
+	resume          bool
+	writeBatch      string
+	readBatch       string
+	mail            mailSettings
+	notify          notifySettings
+	ping            string
+	help            bool
+}
+
+func parseSyncArgs(args []string) (*syncArgs, error) {
+	parsed := &syncArgs{maxDeletes: defaultMaxDeletes, mail: mailSettings{smtp: "localhost:25", on: "always"}, notify: notifySettings{on: "always"}}
 
 	// Parse arguments
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "--to":
 			if i+1 >= len(args) {
-				return fmt.Errorf("--to requires a path argument")
+				return nil, fmt.Errorf("--to requires a path argument")
 			}
 			i++
-			destPath = args[i]
+			parsed.destPath = args[i]
 		case "--name":
 			if i+1 >= len(args) {
-				return fmt.Errorf("--name requires a name argument")
+				return nil, fmt.Errorf("--name requires a name argument")
+			}
+			i++
+			parsed.projectName = args[i]
+		case "--exclude":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--exclude requires a pattern argument")
+			}
+			i++
+			parsed.excludePatterns = append(parsed.excludePatterns, args[i])
+		case "--preset":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--preset requires a name argument")
+			}
+			i++
+			names, err := parsePresets(args[i])
+			if err != nil {
+				return nil, err
+			}
+			parsed.presets = append(parsed.presets, names...)
+		case "--include":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--include requires a pattern argument")
+			}
+			i++
+			parsed.includes = append(parsed.includes, args[i])
+		case "--link":
+			parsed.link = true
+		case "--manifest":
+			parsed.opts.Manifest = true
+		case "--audit-log":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--audit-log requires a path argument")
+			}
+			i++
+			parsed.opts.AuditLog = args[i]
+		case "--bwlimit":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--bwlimit requires a rate argument")
+			}
+			i++
+			limit, err := parseSize(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("--bwlimit: %w", err)
+			}
+			parsed.opts.BwLimit = limit
+		case "--ref":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--ref requires a revision argument")
+			}
+			i++
+			parsed.opts.Ref = args[i]
+		case "--build":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--build requires a command argument")
+			}
+			i++
+			parsed.build = args[i]
+		case "--on-change":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--on-change requires a command argument")
+			}
+			i++
+			parsed.onChange = args[i]
+		case "--from":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--from requires a path argument")
+			}
+			i++
+			if !filepath.IsLocal(args[i]) {
+				return nil, fmt.Errorf("--from must be a directory inside the project: %s", args[i])
+			}
+			parsed.opts.From = args[i]
+		case "--transform":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--transform requires a <pattern>=<name> argument")
+			}
+			i++
+			spec, err := parseTransformSpec(args[i])
+			if err != nil {
+				return nil, err
+			}
+			parsed.opts.Transforms = append(parsed.opts.Transforms, spec)
+		case "--eol":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--eol requires lf, crlf or native")
+			}
+			i++
+			switch args[i] {
+			case "lf", "crlf", "native":
+				parsed.opts.EOL = args[i]
+			default:
+				return nil, fmt.Errorf("--eol: unknown line ending %q (want lf, crlf or native)", args[i])
+			}
+		case "--delete-before":
+			parsed.opts.DeleteBefore = true
+		case "--delete-after":
+			parsed.opts.DeleteBefore = false
+		case "--delete-excluded":
+			parsed.opts.KeepExcluded = false
+		case "--keep-excluded":
+			parsed.opts.KeepExcluded = true
+		case "--protect":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--protect requires a pattern argument")
+			}
+			i++
+			parsed.opts.Protect = append(parsed.opts.Protect, args[i])
+		case "--hard-links", "-H":
+			parsed.opts.HardLinks = true
+		case "--mac-metadata":
+			parsed.opts.MacMetadata = true
+		case "--strip-quarantine":
+			parsed.opts.Unquarantine = true
+		case "--checksum", "-c":
+			parsed.opts.Checksum = true
+		case "--verify":
+			parsed.opts.Verify = true
+		case "--hash":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--hash requires crc64, sha256 or sha512")
+			}
+			i++
+			if err := parseHashAlgorithm(args[i]); err != nil {
+				return nil, err
+			}
+			parsed.opts.Hash = args[i]
+		case "--rescan":
+			parsed.opts.Rescan = true
+		case "--sparse":
+			parsed.opts.Sparse = true
+		case "--itemize", "-i":
+			parsed.opts.Itemize = true
+		case "--only-text":
+			parsed.opts.Only = "text"
+		case "--only-binary":
+			parsed.opts.Only = "binary"
+		case "--filter":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--filter requires a command argument")
+			}
+			i++
+			parsed.opts.Filter = args[i]
+		case "--write-batch":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--write-batch requires a file argument")
+			}
+			i++
+			parsed.writeBatch = args[i]
+		case "--read-batch":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--read-batch requires a file argument")
+			}
+			i++
+			parsed.readBatch = args[i]
+		case "--since":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--since requires a revision argument")
+			}
+			i++
+			parsed.opts.Since = args[i]
+		case "--cron":
+			parsed.cron = true
+		case "--max-deletes":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--max-deletes requires a number argument")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("--max-deletes: invalid number %q", args[i])
+			}
+			parsed.maxDeletes = n
+		case "--max-size":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--max-size requires a size argument")
+			}
+			i++
+			size, err := parseSize(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("--max-size: %w", err)
+			}
+			parsed.opts.MaxSize = size
+		case "--max-files":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--max-files requires a number argument")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("--max-files: invalid number %q", args[i])
+			}
+			parsed.opts.MaxFiles = n
+		case "--budget-warn":
+			parsed.opts.BudgetWarn = true
+		case "--max-delta":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--max-delta requires a percentage argument")
+			}
+			i++
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(args[i], "%"), 64)
+			if err != nil || percent <= 0 || percent > 100 {
+				return nil, fmt.Errorf("--max-delta: invalid percentage %q", args[i])
+			}
+			parsed.opts.MaxDelta = percent
+		case "-y", "--yes":
+			parsed.yes = true
+		case "--resume":
+			parsed.resume = true
+		case "--keep-undo":
+			parsed.opts.KeepUndo = true
+		case "--fsync":
+			parsed.opts.Fsync = true
+		case "--direct-io":
+			parsed.opts.DirectIO = true
+		case "--portable-copy":
+			parsed.opts.PortableCopy = true
+		case "--ignore-file":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--ignore-file requires a path argument")
+			}
+			i++
+			parsed.opts.IgnoreFile = args[i]
+		case "--vss":
+			parsed.opts.VSS = true
+		case "--snapshot":
+			parsed.opts.Snapshot = true
+		case "--apfs-snapshot":
+			parsed.opts.APFSSnapshot = true
+		case "--background":
+			parsed.opts.Background = true
+		case "--ignore-case", "--match-case":
+			ignoreCase = args[i] == "--ignore-case"
+			set := ignoreCase
+			parsed.opts.IgnoreCase = &set
+		case "--mail-to":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--mail-to requires an address argument")
+			}
+			i++
+			for _, addr := range strings.Split(args[i], ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					parsed.mail.to = append(parsed.mail.to, addr)
+				}
+			}
+		case "--mail-from":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--mail-from requires an address argument")
+			}
+			i++
+			parsed.mail.from = args[i]
+		case "--mail-on":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--mail-on requires always, error or delete")
+			}
+			i++
+			if err := parseReportOn("--mail-on", args[i]); err != nil {
+				return nil, err
+			}
+			parsed.mail.on = args[i]
+		case "--smtp":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--smtp requires a host:port argument")
+			}
+			i++
+			parsed.mail.smtp = args[i]
+			if _, _, err := net.SplitHostPort(args[i]); err != nil {
+				parsed.mail.smtp = net.JoinHostPort(args[i], "25")
+			}
+		case "--notify":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--notify requires a webhook URL argument")
+			}
+			i++
+			parsed.notify.urls = append(parsed.notify.urls, args[i])
+		case "--notify-on":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--notify-on requires always, error or delete")
+			}
+			i++
+			if err := parseReportOn("--notify-on", args[i]); err != nil {
+				return nil, err
+			}
+			parsed.notify.on = args[i]
+		case "--notify-template":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--notify-template requires a template argument")
+			}
+			i++
+			tmpl, err := parseNotifyTemplate(args[i])
+			if err != nil {
+				return nil, err
+			}
+			parsed.notify.template = tmpl
+		case "--ping":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--ping requires a URL argument")
+			}
+			i++
+			parsed.ping = args[i]
+		case "--stats-json":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--stats-json requires a path argument")
+			}
+			i++
+			parsed.statsPath = args[i]
+		case "-v", "--verbose":
+			verbosity++
+		case "-vv":
+			verbosity += 2
+		case "--debug-ignore":
+			verbosity = max(verbosity, levelDebug)
+		case "-h", "--help":
+			parsed.help = true
+			return parsed, nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return nil, fmt.Errorf("unknown flag: %s", args[i])
+			}
+			p, err := parseSubPath(args[i])
+			if err != nil {
+				return nil, err
+			}
+			parsed.opts.Paths = append(parsed.opts.Paths, p)
+		}
+	}
+
+	if parsed.destPath == "" {
+		return nil, fmt.Errorf("--to flag is required")
+	}
+	if wholeTree(parsed.opts.Paths) {
+		parsed.opts.Paths = nil
+	}
+	if parsed.build != "" && parsed.opts.Ref != "" {
+		return nil, fmt.Errorf("--build runs in the working tree and can't be combined with --ref")
+	}
+	if parsed.opts.VSS && parsed.opts.Ref != "" {
+		return nil, fmt.Errorf("--vss snapshots the working tree and can't be combined with --ref")
+	}
+	if parsed.opts.Snapshot && parsed.opts.Ref != "" {
+		return nil, fmt.Errorf("--snapshot snapshots the working tree and can't be combined with --ref")
+	}
+	if parsed.writeBatch != "" && parsed.readBatch != "" {
+		return nil, fmt.Errorf("--write-batch and --read-batch can't be combined")
+	}
+	if parsed.link && (parsed.writeBatch != "" || parsed.readBatch != "") {
+		return nil, fmt.Errorf("--link can't be recorded in a batch")
+	}
+	return parsed, nil
+}
+
+// resolve returns the source directory (the working directory) and the
+// absolute destination folder.
+func (a *syncArgs) resolve() (string, string, error) {
+	// Get current working directory
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return "", "", fmt.Errorf("getting working directory: %w", err)
+	}
+
+	// Use current directory name if --name not provided
+	projectName := a.projectName
+	if projectName == "" {
+		projectName = filepath.Base(srcPath)
+	}
+
+	// Build full destination path
+	fullDest, err := filepath.Abs(filepath.Join(a.destPath, projectName))
+	if err != nil {
+		return "", "", err
+	}
+	return srcPath, fullDest, nil
+}
+
+// rules returns the exclusion rules for a sync of dir.
+func (a *syncArgs) rules(dir string) []rule {
+	return append(loadRules(dir, a.opts.IgnoreFile, a.excludePatterns), selectionRules(a.presets, a.includes)...)
+}
+
+// loadRules builds the exclusion rules for srcPath: .git is always
+// excluded, followed by the .gitignore, .git/info/exclude and global git
+// excludes patterns (if present), or by the patterns of ignoreFile
+// instead if given, and any extra user-specified patterns.
+func loadRules(srcPath, ignoreFile string, extra []string) []rule {
+	// Always exclude .git
+	rules := newRules("default", ".git")
+
+	// Another tool's ignore file replaces git's
+	if ignoreFile != "" {
+		rules = append(rules, ignoreFileRules(srcPath, ignoreFile)...)
+		return append(rules, newRules("--exclude", extra...)...)
+	}
+
+	// Parse .gitignore if present
+	gitignorePath := filepath.Join(srcPath, ".gitignore")
+	if gitignoreRules, err := parseGitignore(gitignorePath); err == nil {
+		rules = append(rules, gitignoreRules...)
+	}
+
+	// The repository's local, uncommitted exclusions
+	if excludeRules, err := parseGitignore(filepath.Join(srcPath, ".git", "info", "exclude")); err == nil {
+		for i := range excludeRules {
+			excludeRules[i].Source = ".git/info/exclude"
+		}
+		rules = append(rules, excludeRules...)
+	}
+
+	// The user's excludes for every repository (core.excludesFile)
+	if path := globalExcludesFile(srcPath); path != "" {
+		if globalRules, err := parseGitignore(path); err == nil {
+			for i := range globalRules {
+				globalRules[i].Source = path
+			}
+			rules = append(rules, globalRules...)
+		}
+	}
+
+	// Add user-specified exclusions
+	return append(rules, newRules("--exclude", extra...)...)
+}
+
+func printUsage() {
+	fmt.Println(`rift - Sync project files to a destination
+
+Usage:
+  rift --to <destination> [--name <name>] [--exclude <pattern>]... [--link] [<path>...]
+  rift plan --to <destination> [sync flags] [--output text|json|diff] [--color auto|always|never]
+  rift apply <plan.json>
+  rift clean --to <destination> [--name <name>] [--audit-log <file>]
+  rift undo --to <destination> [--name <name>]
+  rift status
+  rift install-service --every <interval> [--unit <name>] -- <sync flags>
+  rift install-agent --every <interval> [--unit <name>] -- <sync flags>
+  rift list [--sizes | --largest <n>] [--ignore-file <file>] [--exclude <pattern>]... [--preset <name>]... [--include <pattern>]...
+  rift context [--output <file>] [--ignore-file <file>] [--exclude <pattern>]...
+  rift du [--ignore-file <file>] [--exclude <pattern>]... [--preset <name>]... [--include <pattern>]...
+  rift explain [--ignore-file <file>] [--exclude <pattern>]... [--preset <name>]... [--include <pattern>]... <path>...
+  rift package [--version <version>] [--out <dir>] [--name <name>] [--exclude <pattern>]...
+
+Commands:
+  apply       Execute a plan written by rift plan --output json
+  clean       Remove exactly what rift placed at a destination
+  context     Write the project as a Docker build context tar (honours .dockerignore)
+  du          Total the size of what a sync would copy per top-level directory
+  explain     Show which pattern (and where it came from) excludes a path
+  install-agent
+              Run a sync on a schedule with a macOS launchd agent
+  install-service
+              Run a sync on a schedule with a systemd user timer
+  list        Print every file a sync would copy
+  package     Build <name>-<version>.zip from the project (reads .pkgmeta if present)
+  plan        Show the operations a sync would perform without performing them
+  status      Show last sync time and pending changes for each destination
+              (read-only: runs no --filter plugin and updates no cache)
+  undo        Put a destination back the way it was before the last sync
+
+Flags:
+  --to        Destination path (required)
+  --name      Name for destination folder (defaults to current directory name)
+  --exclude   Additional patterns to exclude (repeatable)
+  --preset <name>
+              Exclude what a node, go, python, unity or rust project doesn't
+              need (repeatable or comma-separated)
+  --include <pattern>
+              Sync matching paths even if a preset, .gitignore or --exclude
+              pattern excludes them (repeatable)
+  --ignore-file <file>
+              Exclude by this file instead of .gitignore and git's excludes:
+              .dockerignore (Docker's rules) or e.g. .npmignore (gitignore's)
+              (sync, list, du, explain)
+  --ignore-case, --match-case
+              Match patterns ignoring case (the default on Windows and macOS),
+              or case-sensitively (sync, list, du, explain)
+  --link      Link the destination to the source instead of copying
+  --manifest  Write rift-manifest.json (sizes, mtimes, SHA-256) at the destination
+  --ref <rev> Sync a clean export of a git commit, tag or branch
+  --build <command>
+              Run a build command first and only sync if it succeeds
+  --on-change <command>
+              Run a command after a sync that changed the destination
+  --from <dir>
+              Sync this subdirectory of the project (e.g. dist)
+  --transform <pattern>=<name>
+              Transform matching files while copying (strip-debug, env,
+              minify-json, exec:<command>; repeatable, applied in order)
+  --eol lf|crlf|native
+              Convert line endings of text files while copying
+  --delete-before, --delete-after
+              Remove orphans (and overwrite files that shrink) before
+              copying anything else, or remove them after (the default)
+  --delete-excluded, --keep-excluded
+              Remove destination files matching an exclusion (the default),
+              or leave them in place
+  --protect <pattern>
+              Never delete matching destination paths (repeatable)
+  -H, --hard-links
+              Recreate hard links between source files at the destination
+  --mac-metadata
+              Copy Finder flags, tags and resource forks (macOS)
+  --strip-quarantine
+              Remove quarantine attributes from copied files (macOS)
+  -c, --checksum
+              Compare same-sized files by content instead of modification time
+  --verify    Check every copied file against its source after the sync
+  --hash crc64|sha256|sha512
+              Hash used by --checksum, --verify (default crc64) and
+              --manifest (default sha256)
+  --sparse    Only sync the paths of the repository's sparse-checkout cone
+  --rescan    Walk the whole destination for orphans instead of trusting
+              the record of what the last sync wrote
+  --only-text, --only-binary
+              Only sync text (or binary) files, judged by their content
+  --filter <command>
+              Ask a plugin command which files to sync and where to put them
+  --since <rev>
+              Only sync paths git reports changed since a revision
+  --write-batch <file>
+              Write the changes since the last sync or batch to <file>
+              instead of the destination; files modified no later than
+              that (a --ref export, a copy made with cp -p) are left out
+  --read-batch <file>
+              Apply a batch written by --write-batch to the destination,
+              with --keep-undo, --fsync, --itemize, --max-delta and the
+              like adding to the options it was written with
+  --bwlimit <rate>
+              Limit copying to this many bytes per second (e.g. 500K, 5M)
+  --cron      Print nothing unless the sync fails or deletes more than --max-deletes
+  --max-deletes <n>
+              Deletions a --cron run reports as unusual (default 100)
+  --max-size <size>, --max-files <n>
+              Refuse to sync if the file set is larger than this (e.g. 50M)
+              or has more files than this
+  --budget-warn
+              Only warn when over --max-size or --max-files
+  --max-delta <percent>
+              Ask before deleting or rewriting more than this much of the
+              destination (e.g. 50%)
+  -y, --yes   Don't preview deletions or ask before them or large deltas
+  --keep-undo Keep what each sync overwrites or deletes so rift undo can
+              revert it
+  --resume    Finish a sync that was interrupted rather than undoing it
+  --fsync     Flush every written file and its directory to disk before
+              the sync reports success
+  --direct-io Copy files of 8 MiB or more without filling the page cache
+  --vss       Sync from a Volume Shadow Copy of the source, so files other
+              programs hold locked are copied too (Windows, administrator)
+  --snapshot  Sync from a read-only btrfs snapshot of the source, deleted
+              afterwards (Linux)
+  --apfs-snapshot
+              Take an APFS local snapshot before a sync that deletes files
+              (macOS)
+  --portable-copy
+              Copy with a plain read/write loop instead of the platform's
+              copy (clonefile, CopyFileEx, copy_file_range)
+  --background
+              Run at the lowest CPU and IO priority
+  -i, --itemize
+              Print one line per change saying what differed (rsync style)
+  --stats-json <file>
+              Write run statistics (counts, bytes, phase timings, errors) as JSON
+  --audit-log <file>
+              Append every delete and overwrite to this file (sync, clean)
+  --mail-to <address>
+              Email a summary of the run (repeatable or comma-separated)
+  --mail-on always|error|delete
+              Only email when the run fails, or fails or deletes files
+  --mail-from <address>, --smtp <host[:port]>
+              Sender and mail server for --mail-to (default localhost:25)
+  --notify <webhook>
+              Post a message about the run to a Slack or Discord webhook
+              (repeatable)
+  --notify-on always|error|delete
+              Only post when the run fails, or fails or deletes files
+  --notify-template <template>
+              Go template for the message (fields: .Source, .Dest, .Checked,
+              .Copied, .Deleted, .Bytes, .Duration, .Success, .Error)
+  --ping <url>
+              Ping a dead-man's-switch service (e.g. healthchecks.io) after
+              the run: <url> on success, <url>/fail on failure
+  -v, -vv     Log every change (-v) and every exclusion decision (-vv)
+  --debug-ignore
+              Log every exclusion decision with its pattern and origin
+  --sizes     Show file sizes in bytes (list)
+  --largest <n>
+              Show only the n largest files, biggest first (list)
+  --output    Plan output format: text (default), json or diff (plan)
+  --color     Color the diff output: auto (default), always or never (plan)
+  --version   Package version (package; defaults to git describe --tags)
+  --out       Directory to write the package zip to (package; defaults to .release)
+  -h, --help  Show this help
+
+Examples:
+  rift --to /backup
+  rift --to /games/addons --name MyAddon
+  rift --to /games/addons --link
+  rift --to ~/projects-backup --exclude "*.log" --exclude "tmp/"
+  rift package --version 1.2.0 --out dist`)
+}
+
+// rule is an exclusion pattern together with where it came from, so
+// decisions can be explained.
+type rule struct {
+	Pattern string
+	Source  string // File name, flag or "default"
+	Line    int    // Line number within Source, if it is a file
+	Include bool   // Re-include matches, overriding all but the default rules
+	Negate  bool   // Re-include matches of earlier rules from the same Source, like a .dockerignore "!" line
+}
+
+func (r rule) String() string {
+	if r.Line > 0 {
+		return fmt.Sprintf("%s:%d", r.Source, r.Line)
+	}
+	return r.Source
+}
+
+// newRules wraps patterns that share a source.
+func newRules(source string, patterns ...string) []rule {
+	rules := make([]rule, len(patterns))
+	for i, pattern := range patterns {
+		rules[i] = rule{Pattern: pattern, Source: source}
+	}
+	return rules
+}
+
+func parseGitignore(path string) ([]rule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []rule
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := trimTrailingSpace(strings.TrimLeft(scanner.Text(), " \t"))
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Skip negation patterns for simplicity
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		rules = append(rules, rule{Pattern: line, Source: filepath.Base(path), Line: lineNum})
+	}
+	return rules, scanner.Err()
+}
+
+func shouldExclude(relPath string, rules []rule, isDir bool) bool {
+	return excludedBy(relPath, rules, isDir) != nil
+}
+
+// excludedBy returns the first rule matching relPath, or nil if the path is
+// not excluded. An --include rule matching the path overrides every rule
+// except rift's defaults, and a Negate rule matching it overrides the ones
+// before it from the same source, so within a file the last match wins.
+func excludedBy(relPath string, rules []rule, isDir bool) *rule {
+	// Normalize path separators
+	relPath = filepath.ToSlash(relPath)
+
+	included := includedBy(relPath, rules, isDir) != nil
+	var match *rule
+	for i := range rules {
+		if rules[i].Include || (included && rules[i].Source != "default") {
+			continue
+		}
+		if match != nil && !rules[i].Negate {
+			continue
+		}
+		if !matchPattern(relPath, rules[i].Pattern, isDir) {
+			continue
+		}
+		if !rules[i].Negate {
+			match = &rules[i]
+		} else if match != nil && match.Source == rules[i].Source {
+			match = nil
+		}
+	}
+	return match
+}
+
+// reincludedBelow reports whether something inside relDir, which by
+// excludes, could be brought back: by an --include rule, unless by is one
+// of rift's defaults, or by a Negate rule from the same source as by. An
+// excluded relDir still has to be walked then.
+func reincludedBelow(relDir string, rules []rule, by *rule) bool {
+	parts := strings.Split(filepath.ToSlash(relDir), "/")
+	for _, r := range rules {
+		switch {
+		case r.Include && by.Source != "default":
+		case r.Negate && r.Source == by.Source:
+		default:
+			continue
+		}
+		if parsePattern(r.Pattern).matchesBelow(parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// includedBy returns the first --include rule matching relPath, or nil.
+func includedBy(relPath string, rules []rule, isDir bool) *rule {
+	relPath = filepath.ToSlash(relPath)
+	for i := range rules {
+		if rules[i].Include && matchPattern(relPath, rules[i].Pattern, isDir) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// opKind is the type of a single planned sync operation.
+type opKind string
+
+const (
+	opMkdir  opKind = "mkdir"
+	opCopy   opKind = "copy"
+	opDelete opKind = "delete"
+	opLink   opKind = "link"
+)
+
+// operation is one step of a sync plan. Path is relative to the destination.
+type operation struct {
+	Kind   opKind      `json:"op"`
+	Path   string      `json:"path"`
+	Src    string      `json:"src,omitempty"`    // Source path of a copy, if not Path
+	Target string      `json:"target,omitempty"` // Destination path a link shares its file with
+	New    bool        `json:"new,omitempty"`    // The copy or link creates Path rather than replacing it
+	Size   int64       `json:"size,omitempty"`
+	Mode   fs.FileMode `json:"mode,omitempty"`
+}
+
+// source returns the path of a copy's source file, relative to the source.
+func (op operation) source() string {
+	if op.Src != "" {
+		return op.Src
+	}
+	return op.Path
+}
+
+// syncPlan lists everything needed to bring Dest in line with Src, in the
+// order it will be applied. Files holds every path (relative to Dest) that
+// the destination contains once the plan is applied.
+type syncPlan struct {
+	Src   string
+	Root  string // Directory files are copied from: Src, or an export of it
+	Dest  string
+	Ops   []operation
+	Files []string
+	IDs   map[string]fileID // Identities of the source files, by source path
+	Stats syncStats
+
+	// Existing counts the source paths already at the destination, so
+	// together with the deletes it is what the destination held before
+	Existing int
+}
+
+// deletes reports whether the plan removes anything from the destination.
+func (p *syncPlan) deletes() bool {
+	for _, op := range p.Ops {
+		if op.Kind == opDelete {
+			return true
+		}
+	}
+	return false
+}
+
+// setSource records src as the source of a plan built from an export of
+// it, leaving Root pointing at the export.
+func (p *syncPlan) setSource(src string) {
+	p.Src, p.Stats.Source = src, src
+}
+
+// syncOptions are the settings that change what a sync does beyond which
+// files it copies. They are remembered per destination so that status
+// compares against the same behaviour.
+type syncOptions struct {
+	Manifest     bool            `json:"manifest,omitempty"`      // Write rift-manifest.json at the destination
+	AuditLog     string          `json:"audit_log,omitempty"`     // Append deletes and overwrites to this file
+	BwLimit      int64           `json:"bwlimit,omitempty"`       // Bytes per second across all copies, 0 for no limit
+	Background   bool            `json:"background,omitempty"`    // Run at low CPU and IO priority
+	Ref          string          `json:"ref,omitempty"`           // Sync this git revision instead of the working tree
+	Since        string          `json:"since,omitempty"`         // Only sync paths changed since this git revision
+	From         string          `json:"from,omitempty"`          // Sync this subdirectory of the source (e.g. a build's output)
+	Filter       string          `json:"filter,omitempty"`        // Plugin command that can skip or rename files
+	Transforms   []transformSpec `json:"transforms,omitempty"`    // Content transforms applied while copying
+	EOL          string          `json:"eol,omitempty"`           // Convert text files to lf, crlf or native line endings
+	Only         string          `json:"only,omitempty"`          // Only sync "text" or "binary" files
+	DeleteBefore bool            `json:"delete_before,omitempty"` // Remove orphans before copying rather than after
+	KeepExcluded bool            `json:"keep_excluded,omitempty"` // Leave destination files that match exclusion rules
+	Protect      []string        `json:"protect,omitempty"`       // Destination patterns that are never deleted
+	HardLinks    bool            `json:"hard_links,omitempty"`    // Recreate hard links between source files
+	MacMetadata  bool            `json:"mac_metadata,omitempty"`  // Copy Finder metadata and resource forks (macOS)
+	Unquarantine bool            `json:"unquarantine,omitempty"`  // Remove quarantine attributes from copies (macOS)
+	Checksum     bool            `json:"checksum,omitempty"`      // Compare same-sized files by content, not modification time
+	Verify       bool            `json:"verify,omitempty"`        // Re-read every copy and check it matches its source
+	Hash         string          `json:"hash,omitempty"`          // Hash for checksums and the manifest, if not the default
+	Itemize      bool            `json:"-"`                       // Print an itemized line per change; not remembered
+	Rescan       bool            `json:"-"`                       // Walk the destination for orphans even if it is indexed
+	Sparse       bool            `json:"sparse,omitempty"`        // Only sync the repository's sparse-checkout cone
+	KeepUndo     bool            `json:"keep_undo,omitempty"`     // Keep what a run overwrites or deletes for rift undo
+	Fsync        bool            `json:"fsync,omitempty"`         // Flush every written file and its directory to disk
+	DirectIO     bool            `json:"direct_io,omitempty"`     // Copy large files past the page cache
+	PortableCopy bool            `json:"portable_copy,omitempty"` // Copy with a plain read and write loop, not the platform's copy
+	VSS          bool            `json:"vss,omitempty"`           // Sync from a Volume Shadow Copy of the source (Windows)
+	Snapshot     bool            `json:"snapshot,omitempty"`      // Sync from a read-only btrfs snapshot of the source (Linux)
+	APFSSnapshot bool            `json:"apfs_snapshot,omitempty"` // Take a local snapshot before a run that deletes (macOS)
+	IgnoreFile   string          `json:"ignore_file,omitempty"`   // Exclude by this file (e.g. .dockerignore) instead of git's
+	IgnoreCase   *bool           `json:"ignore_case,omitempty"`   // Match exclusions case-insensitively, if a flag chose
+	Paths        []string        `json:"-"`                       // Only sync these sub-paths of the source; not remembered
+	MaxSize      int64           `json:"max_size,omitempty"`      // Refuse to sync a file set larger than this many bytes
+	MaxFiles     int             `json:"max_files,omitempty"`     // Refuse to sync a file set with more files than this
+	MaxDelta     float64         `json:"max_delta,omitempty"`     // Ask before deleting or rewriting more than this percentage of the destination
+	BudgetWarn   bool            `json:"budget_warn,omitempty"`   // Only warn when over MaxSize or MaxFiles
+
+	scope    changeScope       // Paths changed since Since, filled in by sourceDir
+	index    []string          // Paths the last sync wrote to the destination, if known
+	ids      map[string]fileID // Source file identities recorded by the last sync
+	cone     *sparseCone       // Sparse-checkout cone, filled in by sourceDir
+	readOnly bool              // Plan without writing anything, for status
+	prev     *syncOptions      // Transforms of the last sync, if they differ from these
+}
+
+// snapshotPrefix starts the name of the directory --snapshot creates at the
+// root of a btrfs subvolume.
+const snapshotPrefix = ".rift-snapshot-"
+
+// syncTree brings dest in line with src and returns the plan it applied. If
+// applying fails part-way, the plan is still returned so its statistics
+// reflect what was done.
+func syncTree(src, dest string, rules []rule, opts syncOptions) (*syncPlan, error) {
+	plan, err := buildPlan(src, dest, rules, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plan, executePlan(plan, opts)
+}
+
+// executePlan applies plan and then performs the post-sync steps enabled in
+// opts.
+func executePlan(plan *syncPlan, opts syncOptions) (err error) {
+	if opts.APFSSnapshot && plan.deletes() {
+		date, err := volumeSnapshot(plan.Dest)
+		if err != nil {
+			return fmt.Errorf("snapshotting the destination volume: %w", err)
+		}
+		logf(levelInfo, "snapshot %s", date)
+	}
+
+	journal, err := openUndoJournal(plan.Dest, opts.KeepUndo)
+	if err != nil {
+		return fmt.Errorf("starting undo journal: %w", err)
+	}
+	defer func() {
+		if cerr := journal.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("writing undo journal: %w", cerr)
+		}
+	}()
+	run, err := startRun(plan, opts.Fsync)
+	if err != nil {
+		return fmt.Errorf("starting run log: %w", err)
+	}
+	defer func() {
+		if cerr := run.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("writing run log: %w", cerr)
+		}
+		if err == nil {
+			err = finishRun(plan.Dest)
+		}
+	}()
+
+	if err := applyPlan(plan, opts, journal, run); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := setDirTimes(plan); err != nil {
+		return err
+	}
+	plan.Stats.addPhase("copy", start)
+	if opts.Verify {
+		start := time.Now()
+		if err := verifyCopies(plan, opts); err != nil {
+			return err
+		}
+		plan.Stats.addPhase("verify", start)
+	}
+	if opts.Manifest {
+		start := time.Now()
+		if err := journal.save(manifestName); err != nil {
+			return err
+		}
+		if err := writeManifest(plan, opts.manifestHash()); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
+		}
+		if opts.Fsync {
+			if err := syncWritten(filepath.Join(plan.Dest, manifestName)); err != nil {
+				return err
+			}
+		}
+		plan.Stats.addPhase("manifest", start)
+	}
+	return nil
+}
+
+// setDirTimes gives every directory of plan the modification time of its
+// source directory. This runs once everything has been written, since
+// creating or removing entries updates a directory's time.
+func setDirTimes(plan *syncPlan) error {
+	for _, relPath := range plan.Files {
+		srcInfo, err := os.Stat(filepath.Join(plan.Root, relPath))
+		if err != nil || !srcInfo.IsDir() {
+			continue
+		}
+		destPath := filepath.Join(plan.Dest, relPath)
+		destInfo, err := os.Stat(destPath)
+		if err != nil || destInfo.ModTime().Equal(srcInfo.ModTime()) {
+			continue
+		}
+		if err := os.Chtimes(destPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildPlan compares src against dest without modifying either. Unchanged
+// files (same size and modification time) and existing directories produce
+// no operations.
+func buildPlan(src, dest string, rules []rule, opts syncOptions) (*syncPlan, error) {
+	plan := &syncPlan{Src: src, Root: src, Dest: dest}
+	plan.Stats.Source, plan.Stats.Dest = src, dest
+	defer plan.Stats.addPhase("plan", time.Now())
+
+	// Track valid paths in destination for cleanup
+	validPaths := make(map[string]bool)
+
+	// The manifest is rewritten after every sync rather than planned
+	if opts.Manifest {
+		validPaths[filepath.Join(dest, manifestName)] = true
+	}
+
+	filter, err := startFilter(opts.Filter, src)
+	if err != nil {
+		return nil, err
+	}
+
+	// With --checksum, same-sized files whose content is compared once
+	// the walk is done, and the index of the copy each would need
+	var pairs []hashPair
+	var pending []int
+
+	// With --delete-before, copies that overwrite a larger file, which are
+	// moved ahead of the others to free space early
+	shrinks := make(map[string]bool)
+
+	// With --hard-links, the first destination path of each hard-linked
+	// source file, by file identity
+	linked := make(map[fileID]string)
+
+	// Size and file count of the sync set, checked against the budget
+	var setBytes int64
+	var setFiles int
+
+	err = walkSource(src, rules, func(relPath string, info fs.FileInfo) error {
+		// Sub-path arguments limit the sync to part of the tree
+		if info.IsDir() && !leadsToSubPath(relPath, opts.Paths) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && !underSubPath(relPath, opts.Paths) {
+			return nil
+		}
+
+		// A snapshot shows up inside itself as an empty directory, and one
+		// left behind by a run that was killed as a full copy of the tree
+		if info.IsDir() && strings.HasPrefix(relPath, snapshotPrefix) && !strings.ContainsRune(relPath, filepath.Separator) {
+			return filepath.SkipDir
+		}
+
+		if !opts.cone.contains(relPath, info.IsDir()) {
+			logf(levelDebug, "exclude %s (outside the sparse-checkout cone)", filepath.ToSlash(relPath))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// The --filter plugin may leave files out or move them
+		destRel := relPath
+		if !info.IsDir() {
+			if opts.Only != "" {
+				binary, err := isBinaryFile(filepath.Join(src, relPath))
+				if err != nil {
+					return err
+				}
+				if binary != (opts.Only == "binary") {
+					logf(levelDebug, "exclude %s (not a %s file)", filepath.ToSlash(relPath), opts.Only)
+					return nil
+				}
+			}
+
+			var keep bool
+			var err error
+			if destRel, keep, err = filter.decide(relPath, info.Size()); err != nil || !keep {
+				return err
+			}
+		}
+		for dir := filepath.Dir(destRel); destRel != relPath && dir != "."; dir = filepath.Dir(dir) {
+			if dirPath := filepath.Join(dest, dir); !validPaths[dirPath] {
+				validPaths[dirPath] = true
+				plan.Files = append(plan.Files, dir)
+			}
+		}
+
+		destPath := filepath.Join(dest, destRel)
+		if !validPaths[destPath] {
+			validPaths[destPath] = true
+			plan.Files = append(plan.Files, destRel)
+		}
+		if !info.IsDir() {
+			setBytes += info.Size()
+			setFiles++
+		}
+
+		// Later paths of a hard-linked file are linked to the first
+		var target string
+		if opts.HardLinks && !info.IsDir() {
+			if id, ok := hardLinkID(info); ok {
+				if target, ok = linked[id]; !ok {
+					linked[id] = destRel
+				}
+			}
+		}
+
+		// A file replaced by another one is changed even if its size and
+		// modification time are the same. An export has new files on
+		// every run, and a snapshot is a new device, so there is nothing
+		// to compare
+		replaced := false
+		if id, ok := fileIdentity(info); ok && !info.IsDir() && opts.Ref == "" && !opts.Snapshot {
+			if prev, known := opts.ids[relPath]; known && prev != id {
+				replaced = true
+			}
+			if plan.IDs == nil {
+				plan.IDs = make(map[string]fileID)
+			}
+			plan.IDs[relPath] = id
+		}
+
+		// With --since only changed paths are compared, so the rest of
+		// the destination is never read
+		if !opts.scope.contains(relPath) {
+			plan.Existing++
+			return nil
+		}
+
+		destInfo, err := os.Stat(destPath)
+		if err == nil {
+			plan.Existing++
+		}
+		if info.IsDir() {
+			if err != nil {
+				plan.Ops = append(plan.Ops, operation{Kind: opMkdir, Path: relPath, Mode: info.Mode()})
+			}
+			return nil
+		}
+
+		plan.Stats.FilesChecked++
+
+		if target != "" {
+			if targetInfo, terr := os.Stat(filepath.Join(dest, target)); err == nil && terr == nil && os.SameFile(destInfo, targetInfo) {
+				return nil
+			}
+			plan.Ops = append(plan.Ops, operation{Kind: opLink, Path: destRel, Target: target, New: err != nil})
+			return nil
+		}
+
+		// Skip identical files. Transformed copies differ in size from
+		// their source, so only the modification time is compared, unless
+		// the transforms changed since they were written
+		transformed := len(transformsFor(relPath, opts)) > 0
+		retransform := opts.prev != nil && (transformed || len(transformsFor(relPath, *opts.prev)) > 0)
+		if replaced {
+			logf(levelDebug, "replaced %s", filepath.ToSlash(relPath))
+		} else if retransform {
+			logf(levelDebug, "retransform %s", filepath.ToSlash(relPath))
+		} else if opts.Checksum && err == nil && !transformed && destInfo.Size() == info.Size() {
+			pending = append(pending, len(plan.Ops))
+			pairs = append(pairs, hashPair{src: filepath.Join(src, relPath), dest: destPath})
+		} else if err == nil && (transformed || destInfo.Size() == info.Size()) && destInfo.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		op := operation{Kind: opCopy, Path: destRel, Size: info.Size(), Mode: info.Mode(), New: err != nil}
+		if destRel != relPath {
+			op.Src = relPath
+		}
+		if err == nil && !transformed && destInfo.Size() > info.Size() {
+			shrinks[destRel] = true
+		}
+		plan.Ops = append(plan.Ops, op)
+		return nil
+	})
+	if cerr := filter.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("walking source: %w", err)
+	}
+	if err := opts.checkBudget(setBytes, setFiles); err != nil {
+		return nil, err
+	}
+
+	// Drop the copies of files whose content turned out to match
+	if len(pairs) > 0 {
+		cache := loadChecksumCache(dest, opts.checksumHash())
+		same, err := sameContent(pairs, opts.hashWorkers(), hashAlgorithms[opts.checksumHash()], cache)
+		if err != nil {
+			return nil, fmt.Errorf("comparing checksums: %w", err)
+		}
+		unchanged := make(map[int]bool)
+		for i, ok := range same {
+			if ok {
+				unchanged[pending[i]] = true
+			} else {
+				cache.forget(pairs[i].dest)
 			}
-			i++
-			projectName = args[i]
-		case "--exclude":
-			if i+1 >= len(args) {
-				return fmt.Errorf("--exclude requires a pattern argument")
+		}
+		if !opts.readOnly {
+			if err := cache.save(opts.checksumHash()); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: saving checksum cache: %v\n", err)
 			}
-			i++
-			excludePatterns = append(excludePatterns, args[i])
-		case "-h", "--help":
-			printUsage()
-			return nil
-		default:
-			if strings.HasPrefix(args[i], "-") {
-				return fmt.Errorf("unknown flag: %s", args[i])
+		}
+		ops := plan.Ops[:0]
+		for i, op := range plan.Ops {
+			if !unchanged[i] {
+				ops = append(ops, op)
 			}
 		}
+		plan.Ops = ops
 	}
-
-	if destPath == "" {
-		return fmt.Errorf("--to flag is required")
+	if opts.Manifest {
+		plan.Files = append(plan.Files, manifestName)
 	}
 
-	// Get current working directory
-	srcPath, err := os.Getwd()
+	// Find orphaned files in destination
+	var keep func(relPath string, isDir bool) bool
+	if opts.KeepExcluded || len(opts.Protect) > 0 {
+		protect := newRules("--protect", opts.Protect...)
+		keep = func(relPath string, isDir bool) bool {
+			return shouldExclude(relPath, protect, isDir) || (opts.KeepExcluded && shouldExclude(relPath, rules, isDir))
+		}
+	}
+	var orphans []string
+	if opts.index != nil && opts.scope == nil {
+		orphans, err = indexedOrphans(dest, validPaths, opts.index, keep)
+	} else {
+		orphans, err = findOrphans(dest, validPaths, opts.scope, keep)
+	}
 	if err != nil {
-		return fmt.Errorf("getting working directory: %w", err)
+		return nil, err
 	}
-
-	// Use current directory name if --name not provided
-	if projectName == "" {
-		projectName = filepath.Base(srcPath)
+	orphans = limitOrphans(dest, orphans, opts.Paths)
+	deletes := make([]operation, 0, len(orphans))
+	for _, path := range orphans {
+		relPath, err := filepath.Rel(dest, path)
+		if err != nil {
+			return nil, err
+		}
+		deletes = append(deletes, operation{Kind: opDelete, Path: relPath})
 	}
 
-	// Build full destination path
-	fullDest := filepath.Join(destPath, projectName)
-
-	// Always exclude .git
-	patterns := []string{".git"}
-
-	// Parse .gitignore if present
-	gitignorePath := filepath.Join(srcPath, ".gitignore")
-	if gitignorePatterns, err := parseGitignore(gitignorePath); err == nil {
-		patterns = append(patterns, gitignorePatterns...)
+	// Deleting first, and shrinking files before growing any, frees space
+	// on a nearly full destination; deleting last (the default) keeps the
+	// old files until the new ones are in
+	if opts.DeleteBefore {
+		ops := deletes
+		for _, op := range plan.Ops {
+			if op.Kind == opCopy && shrinks[op.Path] {
+				ops = append(ops, op)
+			}
+		}
+		for _, op := range plan.Ops {
+			if op.Kind != opCopy || !shrinks[op.Path] {
+				ops = append(ops, op)
+			}
+		}
+		plan.Ops = ops
+	} else {
+		plan.Ops = append(plan.Ops, deletes...)
 	}
-
-	// Add user-specified exclusions
-	patterns = append(patterns, excludePatterns...)
-
-	// Perform sync
-	return sync(srcPath, fullDest, patterns)
+	return plan, nil
 }
 
-func printUsage() {
-	fmt.Println(`rift - Sync project files to a destination
-
-Usage:
-  rift --to <destination> [--name <name>] [--exclude <pattern>]...
-
-Flags:
-  --to        Destination path (required)
-  --name      Name for destination folder (defaults to current directory name)
-  --exclude   Additional patterns to exclude (repeatable)
-  -h, --help  Show this help
-
-Examples:
-  rift --to /backup
-  rift --to /games/addons --name MyAddon
-  rift --to ~/projects-backup --exclude "*.log" --exclude "tmp/"`)
+// walkSource calls fn for every path below src that isn't excluded by
+// rules, in lexical order. File info is resolved through symlinks, and
+// symlinked directories are walked like real ones, except a link back to
+// one of its own ancestors, which is skipped with a warning. If fn returns
+// filepath.SkipDir for a directory, its contents are skipped.
+func walkSource(src string, rules []rule, fn func(relPath string, info fs.FileInfo) error) error {
+	root, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return walkDir(src, ".", rules, []fs.FileInfo{root}, fn)
 }
 
-func parseGitignore(path string) ([]string, error) {
-	file, err := os.Open(path)
+// walkDir walks the directory relDir of src. ancestors holds the info of
+// every directory from src down to relDir, to detect symlink cycles.
+func walkDir(src, relDir string, rules []rule, ancestors []fs.FileInfo, fn func(relPath string, info fs.FileInfo) error) error {
+	entries, err := os.ReadDir(filepath.Join(src, relDir))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
 
-	var patterns []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		// Skip negation patterns for simplicity
-		if strings.HasPrefix(line, "!") {
-			continue
-		}
-		patterns = append(patterns, line)
-	}
-	return patterns, scanner.Err()
-}
+	for _, d := range entries {
+		relPath := filepath.Join(relDir, d.Name())
+		path := filepath.Join(src, relPath)
 
-func shouldExclude(relPath string, patterns []string, isDir bool) bool {
-	// Normalize path separators
-	relPath = filepath.ToSlash(relPath)
+		// A symlink counts as whatever it points to
+		isDir := d.IsDir()
+		var info fs.FileInfo
+		var statErr error
+		if d.Type()&fs.ModeSymlink != 0 {
+			if info, statErr = os.Stat(path); statErr == nil {
+				isDir = info.IsDir()
+			}
+		}
 
-	for _, pattern := range patterns {
-		if matchPattern(relPath, pattern, isDir) {
-			return true
+		// Check exclusions. An excluded directory is still walked if an
+		// --include or an exception could re-include something inside it
+		excluded := false
+		if r := excludedBy(relPath, rules, isDir); r != nil {
+			logf(levelDebug, "exclude %s (pattern %q from %s)", filepath.ToSlash(relPath), r.Pattern, r)
+			if !isDir || !reincludedBelow(relPath, rules, r) {
+				continue
+			}
+			excluded = true
+		} else {
+			logf(levelDebug, "include %s", filepath.ToSlash(relPath))
 		}
-	}
-	return false
-}
 
-func matchPattern(relPath, pattern string, isDir bool) bool {
-	pattern = filepath.ToSlash(pattern)
+		if statErr != nil {
+			return statErr
+		}
+		if info == nil {
+			if isDir {
+				info, err = d.Info()
+			} else {
+				info, err = os.Stat(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
 
-	// Handle directory-only patterns (trailing /)
-	dirOnly := strings.HasSuffix(pattern, "/")
-	if dirOnly {
-		pattern = strings.TrimSuffix(pattern, "/")
 		if !isDir {
-			return false
+			if err := fn(relPath, info); err != nil {
+				return err
+			}
+			continue
 		}
-	}
 
-	// Handle ** prefix (matches any path)
-	if strings.HasPrefix(pattern, "**/") {
-		suffix := strings.TrimPrefix(pattern, "**/")
-		// Match against any path component
-		parts := strings.Split(relPath, "/")
-		for i := range parts {
-			subPath := strings.Join(parts[i:], "/")
-			if matched, _ := filepath.Match(suffix, subPath); matched {
-				return true
-			}
-			// Also check just the filename/dirname
-			if matched, _ := filepath.Match(suffix, parts[i]); matched {
-				return true
+		if cycle := ancestorOf(info, ancestors); cycle >= 0 {
+			fmt.Fprintf(os.Stderr, "warning: skipping %s: symlink loops back to %s\n", filepath.ToSlash(relPath), ancestorPath(relPath, len(ancestors)-cycle))
+			continue
+		}
+		if excluded {
+			if err := walkDir(src, relPath, rules, append(ancestors, info), deferDir(relPath, info, fn)); err != nil {
+				return err
 			}
+			continue
+		}
+		if err := fn(relPath, info); err == filepath.SkipDir {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := walkDir(src, relPath, rules, append(ancestors, info), fn); err != nil {
+			return err
 		}
-		return false
 	}
+	return nil
+}
 
-	// Handle patterns without path separator - match against any component
-	if !strings.Contains(pattern, "/") {
-		// Match against the filename/dirname itself
-		base := filepath.Base(relPath)
-		if matched, _ := filepath.Match(pattern, base); matched {
-			return true
+// deferDir wraps fn for the walk of relDir, a directory that is excluded
+// but may hold re-included paths: relDir is only passed to fn before the
+// first of them, so it isn't created when nothing inside it is.
+func deferDir(relDir string, info fs.FileInfo, fn func(relPath string, info fs.FileInfo) error) func(relPath string, info fs.FileInfo) error {
+	reported, skipped := false, false
+	return func(relPath string, entry fs.FileInfo) error {
+		if !reported {
+			reported = true
+			if err := fn(relDir, info); err == filepath.SkipDir {
+				skipped = true
+			} else if err != nil {
+				return err
+			}
 		}
-		// Also match against each path component
-		parts := strings.Split(relPath, "/")
-		for _, part := range parts {
-			if matched, _ := filepath.Match(pattern, part); matched {
-				return true
+		if skipped {
+			if entry.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
-		return false
+		return fn(relPath, entry)
 	}
+}
 
-	// Pattern with / - match from root
-	pattern = strings.TrimPrefix(pattern, "/")
-	matched, _ := filepath.Match(pattern, relPath)
-	return matched
+// ancestorOf returns the index of the directory in ancestors that info
+// refers to, or -1.
+func ancestorOf(info fs.FileInfo, ancestors []fs.FileInfo) int {
+	for i, a := range ancestors {
+		if os.SameFile(info, a) {
+			return i
+		}
+	}
+	return -1
 }
 
-func sync(src, dest string, patterns []string) error {
-	// Track valid paths in destination for cleanup
-	validPaths := make(map[string]bool)
+// ancestorPath returns the path of the directory up levels above relPath,
+// slash-separated and "." for the source root.
+func ancestorPath(relPath string, up int) string {
+	for i := 0; i < up; i++ {
+		relPath = filepath.Dir(relPath)
+	}
+	return filepath.ToSlash(relPath)
+}
 
-	// Walk source directory
-	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// applyPlan executes the operations of plan in order.
+func applyPlan(plan *syncPlan, opts syncOptions, journal *undoJournal, run *runLog) (err error) {
+	audit, err := openAuditLog(opts.AuditLog)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	defer func() {
+		if cerr := audit.Close(); err == nil {
+			err = cerr
 		}
+	}()
 
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
+	limiter := newRateLimiter(opts.BwLimit)
+	stats := &plan.Stats
+	for i, op := range plan.Ops {
+		destPath := filepath.Join(plan.Dest, op.Path)
+		logf(levelInfo, "%s %s", op.Kind, filepath.ToSlash(op.Path))
+		start := time.Now()
+
+		// Itemize against the destination as it was before the change
+		var item string
+		if opts.Itemize {
+			item = itemize(plan, op)
+		}
+		if err := journal.save(op.Path); err != nil {
 			return err
 		}
-
-		// Skip root
-		if relPath == "." {
-			return nil
+		if err := run.record(i, op); err != nil {
+			return err
 		}
 
-		isDir := d.IsDir()
-
-		// Check exclusions
-		if shouldExclude(relPath, patterns, isDir) {
-			if isDir {
-				return filepath.SkipDir
+		switch op.Kind {
+		case opMkdir:
+			if err := os.MkdirAll(destPath, op.Mode); err != nil {
+				return err
 			}
-			return nil
-		}
-
-		destPath := filepath.Join(dest, relPath)
-		validPaths[destPath] = true
-
-		if isDir {
-			// Create directory
-			info, err := d.Info()
+			if opts.Fsync {
+				if err := syncDir(filepath.Dir(destPath)); err != nil {
+					return fmt.Errorf("flushing %s: %w", filepath.Dir(destPath), err)
+				}
+			}
+			stats.DirsCreated++
+			stats.addPhase("copy", start)
+		case opCopy:
+			if err := audit.record("overwrite", destPath, "changed in source"); err != nil {
+				return fmt.Errorf("writing audit log: %w", err)
+			}
+			if err := unlinkSplit(filepath.Join(plan.Root, op.source()), destPath, opts.HardLinks); err != nil {
+				return err
+			}
+			stable, err := copyStable(filepath.Join(plan.Root, op.source()), destPath, limiter, transformsFor(op.source(), opts), opts)
 			if err != nil {
 				return err
 			}
-			return os.MkdirAll(destPath, info.Mode())
+			if !stable {
+				fmt.Fprintf(os.Stderr, "warning: %s kept changing while it was copied; the copy may be torn and is redone next sync\n", filepath.Join(plan.Root, op.source()))
+				stats.FilesUnstable = append(stats.FilesUnstable, filepath.ToSlash(op.Path))
+			}
+			if opts.MacMetadata {
+				if err := copyMacMetadata(filepath.Join(plan.Root, op.source()), destPath); err != nil {
+					return err
+				}
+			}
+			if opts.Unquarantine {
+				if err := stripQuarantine(destPath); err != nil {
+					return err
+				}
+			}
+			// copyFile flushed the file itself
+			if opts.Fsync {
+				if err := syncDir(filepath.Dir(destPath)); err != nil {
+					return fmt.Errorf("flushing %s: %w", filepath.Dir(destPath), err)
+				}
+			}
+			stats.FilesCopied++
+			stats.BytesCopied += op.Size
+			stats.addPhase("copy", start)
+		case opLink:
+			if err := audit.record("overwrite", destPath, "hard link in source"); err != nil {
+				return fmt.Errorf("writing audit log: %w", err)
+			}
+			if err := linkFile(filepath.Join(plan.Dest, op.Target), destPath); err != nil {
+				return err
+			}
+			if opts.Fsync {
+				if err := syncDir(filepath.Dir(destPath)); err != nil {
+					return fmt.Errorf("flushing %s: %w", filepath.Dir(destPath), err)
+				}
+			}
+			stats.FilesLinked++
+			stats.addPhase("copy", start)
+		case opDelete:
+			if err := audit.record("delete", destPath, "not in source"); err != nil {
+				return fmt.Errorf("writing audit log: %w", err)
+			}
+			if err := os.RemoveAll(destPath); err != nil {
+				return fmt.Errorf("removing %s: %w", destPath, err)
+			}
+			if opts.Fsync {
+				if err := syncDir(filepath.Dir(destPath)); err != nil {
+					return fmt.Errorf("flushing %s: %w", filepath.Dir(destPath), err)
+				}
+			}
+			stats.FilesDeleted++
+			stats.addPhase("delete", start)
 		}
 
-		// Copy file
-		return copyFile(path, destPath)
-	})
-
-	if err != nil {
-		return fmt.Errorf("walking source: %w", err)
+		if item != "" {
+			fmt.Fprintln(itemizeOutput, item)
+		}
 	}
+	return nil
+}
 
-	// Clean orphaned files in destination
-	return cleanOrphans(dest, validPaths)
+// errSourceChanged is returned by copyFile when the source changed while it
+// was being copied.
+var errSourceChanged = errors.New("source changed while it was copied")
+
+// copyAttempts is how many times copyStable copies a file that keeps
+// changing before giving up on it.
+const copyAttempts = 3
+
+// copyStable copies src to dest, starting over if src changes during the
+// copy. It reports false if src never held still; dest then has a copy
+// that may be torn, and its modification time doesn't match src, so the
+// next sync copies it again.
+func copyStable(src, dest string, limiter *rateLimiter, chain []transform, opts syncOptions) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		err := copyFile(src, dest, limiter, chain, opts)
+		if !errors.Is(err, errSourceChanged) {
+			return err == nil, err
+		}
+		if attempt == copyAttempts {
+			return false, nil
+		}
+		logf(levelInfo, "retry %s (changed while it was copied)", filepath.ToSlash(src))
+		time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+	}
 }
 
-func copyFile(src, dest string) error {
+func copyFile(src, dest string, limiter *rateLimiter, chain []transform, opts syncOptions) error {
 	// Get source file info
 	info, err := os.Stat(src)
 	if err != nil {
@@ -260,10 +1697,13 @@ func copyFile(src, dest string) error {
 		return err
 	}
 
-	// Check if destination exists and is identical
-	if destInfo, err := os.Stat(dest); err == nil {
-		if destInfo.Size() == info.Size() && destInfo.ModTime().Equal(info.ModTime()) {
-			return nil // Skip identical files
+	// A new file whose bytes don't pass through rift can be copied, or
+	// cloned, by the platform
+	uncached := opts.DirectIO && info.Size() >= uncachedMinSize
+	copied := false
+	if len(chain) == 0 && limiter == nil && !uncached && !opts.PortableCopy {
+		if _, err := os.Lstat(dest); os.IsNotExist(err) {
+			copied = nativeCopy(src, dest)
 		}
 	}
 
@@ -275,28 +1715,216 @@ func copyFile(src, dest string) error {
 	defer srcFile.Close()
 
 	// Create destination
-	destFile, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, info.Mode())
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if copied {
+		flags = os.O_RDWR
+	}
+	destFile, err := os.OpenFile(dest, flags, info.Mode())
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
 
 	// Copy contents
-	if _, err := io.Copy(destFile, srcFile); err != nil {
+	if !copied {
+		if err := copyContent(destFile, srcFile, limiter, chain, uncached, opts.PortableCopy); err != nil {
+			return fmt.Errorf("copying %s: %w", src, err)
+		}
+	}
+
+	// A source that changed while it was read may have been copied half
+	// old, half new
+	if after, err := os.Stat(src); err != nil {
+		return err
+	} else if after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return errSourceChanged
+	}
+
+	// NTFS alternate data streams aren't part of the file's content
+	if err := copyStreams(src, dest); err != nil {
+		return err
+	}
+
+	// An existing file keeps its mode when opened, so set it explicitly
+	if err := destFile.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	// Preserve creation time where the platform allows, then
+	// modification time
+	if err := copyBirthTime(destFile, info); err != nil {
+		return fmt.Errorf("setting creation time of %s: %w", dest, err)
+	}
+	if err := os.Chtimes(dest, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+
+	// Flush through the handle already open for writing: the copy may be
+	// read-only by now, and Windows can't flush a file opened to read
+	if opts.Fsync {
+		if err := destFile.Sync(); err != nil {
+			return fmt.Errorf("flushing %s: %w", dest, err)
+		}
+	}
+	return nil
+}
+
+// copyContent copies srcFile into destFile, through the rate limiter,
+// transforms and page-cache dropping if given. Left alone, io.Copy lets Go
+// use copy_file_range or sendfile where it can; portable forces a plain
+// read and write loop.
+func copyContent(destFile, srcFile *os.File, limiter *rateLimiter, chain []transform, uncached, portable bool) error {
+	var w io.Writer = destFile
+	var r io.Reader = srcFile
+	if portable {
+		w, r = struct{ io.Writer }{w}, struct{ io.Reader }{r}
+	}
+	if limiter != nil {
+		w = &limitedWriter{w: destFile, limiter: limiter}
+	}
+	var uw *uncachedWriter
+	if uncached {
+		if err := setNoCache(srcFile); err != nil {
+			return err
+		}
+		if err := setNoCache(destFile); err != nil {
+			return err
+		}
+		uw = &uncachedWriter{w: w, src: srcFile, dest: destFile}
+		w = uw
+	}
+	if err := copyTransformed(w, r, chain); err != nil {
+		return err
+	}
+	if uw != nil {
+		return uw.flush()
+	}
+	return nil
+}
+
+// linkFile makes dest a hard link to target, replacing any file at dest.
+func linkFile(target, dest string) error {
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(target, dest)
+}
+
+// unlinkSplit removes dest before it is overwritten if it is hard-linked,
+// so the new content doesn't leak into the paths it shares its file with.
+// With hardLinks, a dest whose src is still hard-linked is kept: the paths
+// sharing it are meant to change together. Without it, links left by an
+// earlier -H sync are always broken.
+func unlinkSplit(src, dest string, hardLinks bool) error {
+	destInfo, err := os.Lstat(dest)
+	if err != nil {
+		return nil
+	}
+	if _, ok := hardLinkID(destInfo); !ok {
+		return nil
+	}
+	if !hardLinks {
+		return os.Remove(dest)
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
 		return err
 	}
+	if _, ok := hardLinkID(srcInfo); ok {
+		return nil
+	}
+	return os.Remove(dest)
+}
+
+// indexedOrphans is findOrphans for a destination whose contents are known
+// from the index of its last sync: it returns the indexed paths that are
+// no longer valid, without walking the destination. Files added there by
+// anything else are not found.
+func indexedOrphans(dest string, validPaths map[string]bool, index []string, keep func(relPath string, isDir bool) bool) ([]string, error) {
+	var orphans []string
+	removed := ""
+	for _, relPath := range index {
+		path := filepath.Join(dest, relPath)
+		if validPaths[path] || (removed != "" && strings.HasPrefix(path, removed+string(filepath.Separator))) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if keep != nil {
+			if keep(relPath, info.IsDir()) {
+				continue
+			}
+			// A directory holding kept paths stays; its indexed contents
+			// are still removed one by one
+			if info.IsDir() {
+				kept, err := holdsKept(dest, path, keep)
+				if err != nil {
+					return nil, fmt.Errorf("scanning destination: %w", err)
+				}
+				if kept {
+					continue
+				}
+			}
+		}
+		orphans = append(orphans, path)
+		if info.IsDir() {
+			removed = path
+		}
+	}
+	return orphans, nil
+}
 
-	// Preserve modification time
-	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+// holdsKept reports whether keep is true for anything below dir.
+func holdsKept(dest, dir string, keep func(relPath string, isDir bool) bool) (bool, error) {
+	found := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		relPath, err := filepath.Rel(dest, path)
+		if err != nil {
+			return err
+		}
+		if keep(relPath, d.IsDir()) {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found, err
 }
 
-func cleanOrphans(dest string, validPaths map[string]bool) error {
+// findOrphans returns the destination paths that are not in validPaths,
+// without descending into orphaned directories. With a scope, only its
+// changed files are considered. Paths for which keep returns true are
+// never returned, and neither are the directories containing them; keep
+// may be nil.
+func findOrphans(dest string, validPaths map[string]bool, scope changeScope, keep func(relPath string, isDir bool) bool) ([]string, error) {
 	// If destination doesn't exist, nothing to clean
 	if _, err := os.Stat(dest); os.IsNotExist(err) {
-		return nil
+		return nil, nil
+	}
+
+	// Only changed files can have been deleted from the source
+	if scope != nil {
+		var orphans []string
+		for _, relPath := range scope.files() {
+			path := filepath.Join(dest, relPath)
+			if validPaths[path] || (keep != nil && keep(relPath, false)) {
+				continue
+			}
+			if _, err := os.Lstat(path); err == nil {
+				orphans = append(orphans, path)
+			}
+		}
+		return orphans, nil
 	}
 
-	var toRemove []string
+	var candidates []string
+	orphanDirs := make(map[string]bool)
+	holdsKept := make(map[string]bool)
 
 	err := filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -308,11 +1936,32 @@ func cleanOrphans(dest string, validPaths map[string]bool) error {
 			return nil
 		}
 
+		if keep != nil {
+			relPath, err := filepath.Rel(dest, path)
+			if err != nil {
+				return err
+			}
+			if keep(relPath, d.IsDir()) {
+				for dir := filepath.Dir(path); dir != dest; dir = filepath.Dir(dir) {
+					holdsKept[dir] = true
+				}
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
 		// If path is not in valid paths, mark for removal
 		if !validPaths[path] {
-			toRemove = append(toRemove, path)
+			candidates = append(candidates, path)
 			if d.IsDir() {
-				return filepath.SkipDir // Don't descend into dirs we'll remove
+				orphanDirs[path] = true
+				// Don't descend into dirs we'll remove, unless something
+				// inside them may have to be kept
+				if keep == nil {
+					return filepath.SkipDir
+				}
 			}
 		}
 
@@ -320,15 +1969,25 @@ func cleanOrphans(dest string, validPaths map[string]bool) error {
 	})
 
 	if err != nil {
-		return fmt.Errorf("scanning destination: %w", err)
+		return nil, fmt.Errorf("scanning destination: %w", err)
 	}
 
-	// Remove orphaned paths
-	for _, path := range toRemove {
-		if err := os.RemoveAll(path); err != nil {
-			return fmt.Errorf("removing %s: %w", path, err)
+	// An orphaned directory holding kept paths stays, and only its other
+	// contents are removed. Otherwise it is removed whole, which covers
+	// everything below it.
+	var orphans []string
+	removed := ""
+	for _, path := range candidates {
+		if removed != "" && strings.HasPrefix(path, removed+string(filepath.Separator)) {
+			continue
+		}
+		if holdsKept[path] {
+			continue
+		}
+		orphans = append(orphans, path)
+		if orphanDirs[path] {
+			removed = path
 		}
 	}
-
-	return nil
+	return orphans, nil
 }
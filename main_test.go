@@ -1,11 +1,36 @@
 package main
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// Keep sync state out of the real user cache directory
+	dir, err := os.MkdirTemp("", "rift-state-")
+	if err != nil {
+		panic(err)
+	}
+	_ = os.Setenv("RIFT_STATE_DIR", dir)
+
+	// Keep the user's global git excludes out of the exclusion rules
+	_ = os.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	_ = os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	_ = os.Setenv("XDG_CONFIG_HOME", dir)
+
+	// Never wait for an answer on the terminal go test runs in
+	interactive = func() bool { return false }
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestMatchPattern(t *testing.T) {
 	tests := []struct {
 		relPath  string
@@ -48,7 +73,7 @@ func TestMatchPattern(t *testing.T) {
 }
 
 func TestShouldExclude(t *testing.T) {
-	patterns := []string{".git", "*.log", "node_modules/", "dist/"}
+	rules := newRules("test", ".git", "*.log", "node_modules/", "dist/")
 
 	tests := []struct {
 		relPath  string
@@ -67,7 +92,7 @@ func TestShouldExclude(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.relPath, func(t *testing.T) {
-			got := shouldExclude(tt.relPath, patterns, tt.isDir)
+			got := shouldExclude(tt.relPath, rules, tt.isDir)
 			if got != tt.expected {
 				t.Errorf("shouldExclude(%q, patterns, %v) = %v, want %v",
 					tt.relPath, tt.isDir, got, tt.expected)
@@ -93,23 +118,45 @@ dist/
 		t.Fatal(err)
 	}
 
-	patterns, err := parseGitignore(gitignorePath)
+	rules, err := parseGitignore(gitignorePath)
 	if err != nil {
 		t.Fatalf("parseGitignore() error = %v", err)
 	}
 
-	expected := []string{"*.log", "node_modules/", "dist/"}
-	if len(patterns) != len(expected) {
-		t.Fatalf("got %d patterns, want %d", len(patterns), len(expected))
+	expected := []rule{
+		{Pattern: "*.log", Source: ".gitignore", Line: 2},
+		{Pattern: "node_modules/", Source: ".gitignore", Line: 3},
+		{Pattern: "dist/", Source: ".gitignore", Line: 6},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(expected))
 	}
 
-	for i, p := range expected {
-		if patterns[i] != p {
-			t.Errorf("patterns[%d] = %q, want %q", i, patterns[i], p)
+	for i, r := range expected {
+		if rules[i] != r {
+			t.Errorf("rules[%d] = %+v, want %+v", i, rules[i], r)
 		}
 	}
 }
 
+func TestLoadRulesGitInfoExclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".git", "info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".git", "info", "exclude"), []byte("# local\nscratch/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := excludedBy("scratch/notes.txt", loadRules(dir, "", nil), false)
+	if r == nil {
+		t.Fatal("scratch/notes.txt should be excluded by .git/info/exclude")
+	}
+	if got := r.String(); got != ".git/info/exclude:2" {
+		t.Errorf("rule source = %q, want %q", got, ".git/info/exclude:2")
+	}
+}
+
 func TestParseGitignoreMissing(t *testing.T) {
 	_, err := parseGitignore("/nonexistent/.gitignore")
 	if err == nil {
@@ -172,9 +219,9 @@ func TestSync(t *testing.T) {
 	}
 
 	// Sync with exclusion
-	err := sync(srcDir, destDir, []string{"*.log"})
+	_, err := syncTree(srcDir, destDir, newRules("--exclude", "*.log"), syncOptions{})
 	if err != nil {
-		t.Fatalf("sync() error = %v", err)
+		t.Fatalf("syncTree() error = %v", err)
 	}
 
 	// Verify files exist
@@ -206,9 +253,9 @@ func TestSyncRemovesOrphans(t *testing.T) {
 	}
 
 	// Sync
-	err := sync(srcDir, destDir, nil)
+	_, err := syncTree(srcDir, destDir, nil, syncOptions{})
 	if err != nil {
-		t.Fatalf("sync() error = %v", err)
+		t.Fatalf("syncTree() error = %v", err)
 	}
 
 	// Verify orphan was removed
@@ -222,6 +269,55 @@ func TestSyncRemovesOrphans(t *testing.T) {
 	}
 }
 
+func TestBuildPlan(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "same.txt"), []byte("same"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "newdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "newdir", "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change one file, add an orphan
+	if err := os.WriteFile(filepath.Join(srcDir, "newdir", "new.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "orphan.txt"), []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+
+	expected := []operation{
+		{Kind: opCopy, Path: filepath.Join("newdir", "new.txt"), Size: 7, Mode: 0644},
+		{Kind: opDelete, Path: "orphan.txt"},
+	}
+	if len(plan.Ops) != len(expected) {
+		t.Fatalf("got %d operations %+v, want %d", len(plan.Ops), plan.Ops, len(expected))
+	}
+	for i, op := range expected {
+		if plan.Ops[i] != op {
+			t.Errorf("Ops[%d] = %+v, want %+v", i, plan.Ops[i], op)
+		}
+	}
+
+	// Planning must not touch the destination
+	if _, err := os.Stat(filepath.Join(destDir, "orphan.txt")); err != nil {
+		t.Error("buildPlan should not remove orphans")
+	}
+}
+
 func TestRunWithNameFlag(t *testing.T) {
 	srcDir := t.TempDir()
 	destDir := t.TempDir()
@@ -284,3 +380,584 @@ func TestRunWithoutNameFlag(t *testing.T) {
 		t.Errorf("test.txt should exist in %s destination folder", srcDirName)
 	}
 }
+
+func TestParseSyncArgsBackground(t *testing.T) {
+	parsed, err := parseSyncArgs([]string{"--to", "/tmp", "--background"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs() error = %v", err)
+	}
+	if !parsed.opts.Background {
+		t.Error("--background should set opts.Background")
+	}
+}
+
+func TestParseSyncArgsVSS(t *testing.T) {
+	parsed, err := parseSyncArgs([]string{"--to", "/tmp", "--vss"})
+	if err != nil {
+		t.Fatalf("parseSyncArgs() error = %v", err)
+	}
+	if !parsed.opts.VSS {
+		t.Error("--vss should set opts.VSS")
+	}
+	if _, err := parseSyncArgs([]string{"--to", "/tmp", "--vss", "--ref", "HEAD"}); err == nil {
+		t.Error("expected error combining --vss with --ref")
+	}
+	if runtime.GOOS != "windows" {
+		if _, _, err := sourceDir(t.TempDir(), &parsed.opts); err == nil {
+			t.Error("expected error for --vss outside Windows")
+		}
+	}
+}
+
+func TestBuildPlanSkipsSnapshotPlaceholder(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	// One left behind by a killed run holds a full copy of the tree
+	if err := os.Mkdir(filepath.Join(srcDir, ".rift-snapshot-0123abcd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, ".rift-snapshot-0123abcd", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	if len(plan.Ops) != 1 || plan.Ops[0].Path != "a.txt" {
+		t.Errorf("ops = %+v, want only a.txt copied", plan.Ops)
+	}
+	if _, err := parseSyncArgs([]string{"--to", "/tmp", "--snapshot", "--ref", "HEAD"}); err == nil {
+		t.Error("expected error combining --snapshot with --ref")
+	}
+}
+
+func TestBuildPlanDeleteBefore(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		opts  syncOptions
+		first opKind
+	}{
+		{syncOptions{}, opCopy},
+		{syncOptions{DeleteBefore: true}, opDelete},
+	} {
+		plan, err := buildPlan(srcDir, destDir, nil, tt.opts)
+		if err != nil {
+			t.Fatalf("buildPlan() error = %v", err)
+		}
+		if len(plan.Ops) != 2 || plan.Ops[0].Kind != tt.first {
+			t.Errorf("DeleteBefore=%v: ops = %+v, want %s first", tt.opts.DeleteBefore, plan.Ops, tt.first)
+		}
+	}
+}
+
+func TestBuildPlanDeleteBeforeShrinksFirst(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	for name, content := range map[string]string{"grow.txt": "longer now", "shrink.txt": "s", "new.txt": "new"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{"grow.txt": "g", "shrink.txt": "was longer", "old.txt": "old"} {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{DeleteBefore: true})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	var got []string
+	for _, op := range plan.Ops {
+		got = append(got, string(op.Kind)+" "+op.Path)
+	}
+	want := []string{"delete old.txt", "copy shrink.txt", "copy grow.txt", "copy new.txt"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("ops = %v, want %v", got, want)
+	}
+}
+
+func TestBuildPlanKeepExcluded(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "app.txt"), []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app.txt", "debug.log", "old/x.log", "old/y.txt", "gone/z.txt"} {
+		path := filepath.Join(destDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := newRules("--exclude", "*.log")
+
+	for _, tt := range []struct {
+		opts    syncOptions
+		deletes []string
+	}{
+		{syncOptions{}, []string{"debug.log", "gone", "old"}},
+		{syncOptions{KeepExcluded: true}, []string{"gone", filepath.Join("old", "y.txt")}},
+	} {
+		plan, err := buildPlan(srcDir, destDir, rules, tt.opts)
+		if err != nil {
+			t.Fatalf("buildPlan() error = %v", err)
+		}
+		var deletes []string
+		for _, op := range plan.Ops {
+			if op.Kind == opDelete {
+				deletes = append(deletes, op.Path)
+			}
+		}
+		if len(deletes) != len(tt.deletes) {
+			t.Errorf("KeepExcluded=%v: deletes = %q, want %q", tt.opts.KeepExcluded, deletes, tt.deletes)
+			continue
+		}
+		for i, want := range tt.deletes {
+			if deletes[i] != want {
+				t.Errorf("KeepExcluded=%v: deletes[%d] = %q, want %q", tt.opts.KeepExcluded, i, deletes[i], want)
+			}
+		}
+	}
+}
+
+func TestSyncProtect(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "addon.lua"), []byte("addon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"SavedVariables/addon.lua", "app.local.conf", "stale.txt"} {
+		path := filepath.Join(destDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := syncOptions{Protect: []string{"SavedVariables/", "*.local.conf"}}
+	if _, err := syncTree(srcDir, destDir, nil, opts); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	for _, name := range []string{"SavedVariables/addon.lua", "app.local.conf"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err != nil {
+			t.Errorf("protected %s should not be deleted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "stale.txt")); err == nil {
+		t.Error("stale.txt should have been removed")
+	}
+}
+
+func TestSyncFollowsSymlinkedDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on Windows")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "shared", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("shared", filepath.Join(srcDir, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	// A link back to an ancestor would otherwise recurse forever
+	if err := os.Symlink("..", filepath.Join(srcDir, "shared", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "alias", "a.txt")); err != nil {
+		t.Error("alias/a.txt should be copied through the symlinked directory")
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, "shared", "loop")); err == nil {
+		t.Error("shared/loop points back to an ancestor and should be skipped")
+	}
+}
+
+func TestSyncHardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not detected on Windows")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(srcDir, "a.txt"), filepath.Join(srcDir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := syncOptions{HardLinks: true}
+	plan, err := syncTree(srcDir, destDir, nil, opts)
+	if err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+	if plan.Stats.FilesCopied != 1 || plan.Stats.FilesLinked != 1 {
+		t.Errorf("copied %d and linked %d files, want 1 and 1", plan.Stats.FilesCopied, plan.Stats.FilesLinked)
+	}
+	a, _ := os.Stat(filepath.Join(destDir, "a.txt"))
+	b, _ := os.Stat(filepath.Join(destDir, "b.txt"))
+	if a == nil || b == nil || !os.SameFile(a, b) {
+		t.Fatal("a.txt and b.txt should be hard-linked at the destination")
+	}
+
+	plan, err = buildPlan(srcDir, destDir, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Ops) != 0 {
+		t.Errorf("second plan should be empty, got %+v", plan.Ops)
+	}
+
+	// Once the source files are separate, changing one must not change
+	// the other at the destination
+	if err := os.Remove(filepath.Join(srcDir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "b.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "a.txt")); string(data) != "shared" {
+		t.Errorf("a.txt = %q, want %q", data, "shared")
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "b.txt")); string(data) != "changed" {
+		t.Errorf("b.txt = %q, want %q", data, "changed")
+	}
+}
+
+func TestSyncBreaksHardLinksWithoutH(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not detected on Windows")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(srcDir, "a.txt"), filepath.Join(srcDir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{HardLinks: true}); err != nil {
+		t.Fatalf("syncTree() with -H error = %v", err)
+	}
+
+	// A sync without -H must not write a.txt's new content through the
+	// link the -H sync left at the destination
+	if err := os.Remove(filepath.Join(srcDir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "a.txt")); string(data) != "changed" {
+		t.Errorf("a.txt = %q, want %q", data, "changed")
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "b.txt")); string(data) != "shared" {
+		t.Errorf("b.txt = %q, want %q", data, "shared")
+	}
+}
+
+func TestSyncPreservesDirTimes(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	sub := filepath.Join(srcDir, "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(sub, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(destDir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("sub modification time = %v, want %v", info.ModTime(), old)
+	}
+}
+
+func TestBuildPlanIndex(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := syncTree(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(srcDir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "foreign.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index only knows what the last sync wrote
+	indexed, err := buildPlan(srcDir, destDir, nil, syncOptions{index: plan.Files})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	want := []operation{{Kind: opDelete, Path: "b.txt"}}
+	if len(indexed.Ops) != 1 || indexed.Ops[0] != want[0] {
+		t.Errorf("indexed plan = %+v, want %+v", indexed.Ops, want)
+	}
+
+	// A full walk also finds the foreign file
+	walked, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walked.Ops) != 2 {
+		t.Errorf("walked plan = %+v, want deletes of b.txt and foreign.txt", walked.Ops)
+	}
+}
+
+func TestBuildPlanReplacedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file identities are not tracked on Windows")
+	}
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	path := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(path, []byte("before"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := syncTree(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the file the way a build tool preserving timestamps would
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(srcDir, "a.tmp")
+	if err := os.WriteFile(tmp, []byte("after!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, err := buildPlan(srcDir, destDir, nil, syncOptions{ids: plan.IDs})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	if len(replaced.Ops) != 1 || replaced.Ops[0].Path != "a.txt" {
+		t.Errorf("plan = %+v, want a copy of the replaced a.txt", replaced.Ops)
+	}
+
+	// Every --snapshot is a new device, so identities aren't compared
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	moved := map[string]fileID{"a.txt": {Dev: 1, Ino: 1}}
+	snapshot, err := buildPlan(srcDir, destDir, nil, syncOptions{ids: moved, Snapshot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Ops) != 0 {
+		t.Errorf("snapshot plan = %+v, want nothing to do", snapshot.Ops)
+	}
+}
+
+func TestSyncTreeFsync(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A read-only copy is flushed before it can't be opened for writing
+	if err := os.WriteFile(filepath.Join(srcDir, "ro.txt"), []byte("ro"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{Fsync: true, Manifest: true}); err != nil {
+		t.Fatalf("syncTree() with Fsync error = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(destDir, "sub", "a.txt")); err != nil || string(got) != "hello" {
+		t.Errorf("sub/a.txt = %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "old.txt")); !os.IsNotExist(err) {
+		t.Error("old.txt should have been deleted")
+	}
+}
+
+func TestSyncTreeDirectIO(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	// Large enough to go past the cache, and not a multiple of the
+	// flushing interval
+	data := make([]byte, uncachedMinSize*2+12345)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "big.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{DirectIO: true}); err != nil {
+		t.Fatalf("syncTree() with DirectIO error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(destDir, "big.bin"))
+	if err != nil || string(got) != string(data) {
+		t.Errorf("big.bin differs from its source after an uncached copy (err = %v)", err)
+	}
+}
+
+func TestSyncTreePortableCopy(t *testing.T) {
+	for _, portable := range []bool{false, true} {
+		srcDir := t.TempDir()
+		destDir := t.TempDir()
+
+		if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("hello"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(srcDir, "a.txt"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := syncTree(srcDir, destDir, nil, syncOptions{PortableCopy: portable}); err != nil {
+			t.Fatalf("syncTree() PortableCopy=%v error = %v", portable, err)
+		}
+		got, err := os.ReadFile(filepath.Join(destDir, "a.txt"))
+		if err != nil || string(got) != "hello" {
+			t.Errorf("PortableCopy=%v: a.txt = %q, %v", portable, got, err)
+		}
+		if info, err := os.Stat(filepath.Join(destDir, "a.txt")); err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("PortableCopy=%v: modification time not preserved", portable)
+		}
+	}
+}
+
+func TestCopyStable(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "growing.log")
+	dest := filepath.Join(dir, "copy.log")
+	if err := os.WriteFile(src, []byte("first line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transform stands in for a program appending to the source while
+	// it is read: once, then during every attempt
+	for _, tt := range []struct {
+		changes    int
+		wantStable bool
+	}{
+		{1, true},
+		{copyAttempts, false},
+	} {
+		changed := 0
+		appending := func(w io.Writer, r io.Reader) error {
+			if changed < tt.changes {
+				changed++
+				f, err := os.OpenFile(src, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					return err
+				}
+				_, err = f.WriteString("another line\n")
+				f.Close()
+				if err != nil {
+					return err
+				}
+			}
+			_, err := io.Copy(w, r)
+			return err
+		}
+
+		stable, err := copyStable(src, dest, nil, []transform{appending}, syncOptions{})
+		if err != nil || stable != tt.wantStable {
+			t.Errorf("copyStable() with %d changes = %v, %v, want %v", tt.changes, stable, err, tt.wantStable)
+		}
+		if stable {
+			want, _ := os.ReadFile(src)
+			if got, _ := os.ReadFile(dest); string(got) != string(want) {
+				t.Errorf("stable copy = %q, want %q", got, want)
+			}
+		}
+	}
+}
+
+func TestExecutePlanAPFSSnapshot(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("takes a real local snapshot on macOS")
+	}
+	destDir := t.TempDir()
+
+	// Only a plan that deletes needs the snapshot
+	if err := executePlan(&syncPlan{Dest: destDir}, syncOptions{APFSSnapshot: true}); err != nil {
+		t.Errorf("executePlan() without deletes error = %v", err)
+	}
+	plan := &syncPlan{Dest: destDir, Ops: []operation{{Kind: opDelete, Path: "old.txt"}}}
+	if err := executePlan(plan, syncOptions{APFSSnapshot: true}); err == nil {
+		t.Error("expected error for --apfs-snapshot outside macOS")
+	}
+}
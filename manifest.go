@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestName is the file written at the destination root by --manifest.
+const manifestName = "rift-manifest.json"
+
+// manifest describes every file rift deployed to a destination.
+type manifest struct {
+	Source        string          `json:"source"`
+	SyncedAt      time.Time       `json:"synced_at"`
+	HashAlgorithm string          `json:"hash_algorithm"`
+	Files         []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"`
+}
+
+// writeManifest records the files of an applied plan at its destination,
+// hashed with the named algorithm. Hashes from the previous manifest are
+// reused for files whose size and modification time are unchanged, so only
+// copied files are re-read.
+func writeManifest(plan *syncPlan, algorithm string) error {
+	manifestPath := filepath.Join(plan.Dest, manifestName)
+
+	previous := make(map[string]manifestEntry)
+	if old, err := readManifest(manifestPath); err == nil && old.HashAlgorithm == algorithm {
+		for _, entry := range old.Files {
+			previous[entry.Path] = entry
+		}
+	}
+
+	m := manifest{
+		Source:        plan.Src,
+		SyncedAt:      time.Now().UTC(),
+		HashAlgorithm: algorithm,
+		Files:         []manifestEntry{},
+	}
+
+	for _, relPath := range plan.Files {
+		if relPath == manifestName {
+			continue
+		}
+		path := filepath.Join(plan.Dest, relPath)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		entry := manifestEntry{
+			Path:    filepath.ToSlash(relPath),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		}
+		if old, ok := previous[entry.Path]; ok && old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) {
+			entry.Hash = old.Hash
+		} else if entry.Hash, err = hashFile(path, hashAlgorithms[algorithm]); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, entry)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, append(data, '\n'), 0644)
+}
+
+func readManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// hashFile returns the hex-encoded hash of a file's contents.
+func hashFile(path string, newHash func() hash.Hash) (string, error) {
+	sum, err := fileDigest(path, newHash)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
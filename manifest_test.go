@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncWritesManifest(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := syncOptions{Manifest: true}
+	if _, err := syncTree(srcDir, destDir, nil, opts); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	m, err := readManifest(filepath.Join(destDir, manifestName))
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	if len(m.Files) != 1 {
+		t.Fatalf("got %d manifest entries, want 1", len(m.Files))
+	}
+
+	entry := m.Files[0]
+	if entry.Path != "sub/hello.txt" || entry.Size != 5 {
+		t.Errorf("entry = %+v, want sub/hello.txt with size 5", entry)
+	}
+	// sha256("hello")
+	if want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"; entry.Hash != want {
+		t.Errorf("entry.Hash = %q, want %q", entry.Hash, want)
+	}
+
+	// A second sync must neither plan to delete the manifest nor drop it
+	plan, err := buildPlan(srcDir, destDir, nil, opts)
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	if len(plan.Ops) != 0 {
+		t.Errorf("expected no operations after sync, got %+v", plan.Ops)
+	}
+
+	// Without --manifest the file is just another orphan
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, manifestName)); err == nil {
+		t.Error("manifest should be removed when --manifest is no longer used")
+	}
+}
+
+func TestManifestHashAlgorithm(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Switching algorithms must rehash rather than reuse the old hashes
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{Manifest: true, Hash: "sha512"}); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+	m, err := readManifest(filepath.Join(destDir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HashAlgorithm != "sha512" || len(m.Files) != 1 || len(m.Files[0].Hash) != 128 {
+		t.Errorf("manifest = %+v, want one sha512 entry", m)
+	}
+}
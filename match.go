@@ -0,0 +1,302 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultIgnoreCase follows the platform's usual filesystem: Windows and
+// macOS treat names that differ only in case as the same file.
+const defaultIgnoreCase = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// ignoreCase makes pattern matching case-insensitive. It is set by
+// --ignore-case and --match-case.
+var ignoreCase = defaultIgnoreCase
+
+// matchPattern reports whether relPath (slash-separated, relative to the
+// source root) matches a gitignore-style pattern, either itself or through
+// one of its parent directories.
+//
+// Patterns follow gitignore(5): a trailing "/" matches only directories; a
+// pattern containing any other "/" is anchored to the root, while one
+// without matches a name at any depth; "**" spans any number of
+// directories; "*", "?" and "[...]" (with ranges, "!"/"^" negation and
+// [:class:] names) match within a single path component; "\" escapes the
+// next character.
+func matchPattern(relPath, pattern string, isDir bool) bool {
+	p := parsePattern(pattern)
+	parts := strings.Split(relPath, "/")
+
+	for i := 1; i <= len(parts); i++ {
+		// Every prefix but the full path is a directory
+		if p.dirOnly && i == len(parts) && !isDir {
+			continue
+		}
+		if p.matches(parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// globPattern is a pattern split into slash-separated segments.
+type globPattern struct {
+	segments []string
+	anchored bool
+	dirOnly  bool
+}
+
+func parsePattern(pattern string) globPattern {
+	var p globPattern
+
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// A leading or middle slash anchors the pattern to the root
+	if strings.HasPrefix(pattern, "/") {
+		p.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else if strings.Contains(pattern, "/") {
+		p.anchored = true
+	}
+
+	p.segments = strings.Split(pattern, "/")
+	return p
+}
+
+// matches reports whether the pattern matches the path given as components.
+func (p globPattern) matches(parts []string) bool {
+	if !p.anchored {
+		return matchSegment(p.segments[0], parts[len(parts)-1])
+	}
+	return matchSegments(p.segments, parts)
+}
+
+// matchesBelow reports whether the pattern could match something inside the
+// directory given as components.
+func (p globPattern) matchesBelow(parts []string) bool {
+	if !p.anchored {
+		return true
+	}
+	segments := p.segments
+	for _, part := range parts {
+		if len(segments) == 0 {
+			return false
+		}
+		if segments[0] == "**" {
+			return true
+		}
+		if !matchSegment(segments[0], part) {
+			return false
+		}
+		segments = segments[1:]
+	}
+	return len(segments) > 0
+}
+
+// matchSegments matches pattern segments against path components, with
+// "**" standing for zero or more components. A trailing "**" matches only
+// what is inside a directory, not the directory itself.
+func matchSegments(segments, parts []string) bool {
+	for len(segments) > 0 {
+		if segments[0] == "**" {
+			rest := segments[1:]
+			if len(rest) == 0 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(rest, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 || !matchSegment(segments[0], parts[0]) {
+			return false
+		}
+		segments, parts = segments[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// matchSegment matches a single path component against a pattern segment
+// containing no slashes.
+func matchSegment(pattern, name string) bool {
+	px, nx := 0, 0
+
+	// Restart point for the most recent "*"
+	starPx, starNx := -1, 0
+
+	for px < len(pattern) || nx < len(name) {
+		if px < len(pattern) {
+			switch pattern[px] {
+			case '*':
+				starPx, starNx = px, nx
+				px++
+				continue
+			case '?':
+				if nx < len(name) {
+					_, w := utf8.DecodeRuneInString(name[nx:])
+					px++
+					nx += w
+					continue
+				}
+			case '[':
+				if nx < len(name) {
+					r, w := utf8.DecodeRuneInString(name[nx:])
+					if matched, n, ok := matchClass(pattern[px:], r); ok {
+						if matched {
+							px += n
+							nx += w
+							continue
+						}
+					} else if name[nx] == '[' {
+						// Unterminated class: a literal "["
+						px++
+						nx++
+						continue
+					}
+				}
+			default:
+				if nx < len(name) {
+					pr, pw := readLiteral(pattern[px:])
+					nr, nw := utf8.DecodeRuneInString(name[nx:])
+					if runesEqual(pr, nr) {
+						px += pw
+						nx += nw
+						continue
+					}
+				}
+			}
+		}
+
+		// Mismatch: let the last "*" swallow one more character
+		if starPx >= 0 && starNx < len(name) {
+			_, w := utf8.DecodeRuneInString(name[starNx:])
+			starNx += w
+			px, nx = starPx+1, starNx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// readLiteral decodes the next pattern character, honouring "\" escapes.
+// A trailing backslash matches itself.
+func readLiteral(pattern string) (rune, int) {
+	if pattern[0] == '\\' && len(pattern) > 1 {
+		r, w := utf8.DecodeRuneInString(pattern[1:])
+		return r, w + 1
+	}
+	return utf8.DecodeRuneInString(pattern)
+}
+
+// runesEqual compares two characters, ignoring case if ignoreCase is set.
+func runesEqual(a, b rune) bool {
+	return a == b || ignoreCase && unicode.ToLower(a) == unicode.ToLower(b)
+}
+
+// inRange reports whether r lies within lo-hi, trying both cases of r if
+// ignoreCase is set.
+func inRange(r, lo, hi rune) bool {
+	if lo <= r && r <= hi {
+		return true
+	}
+	if !ignoreCase {
+		return false
+	}
+	for _, c := range []rune{unicode.ToLower(r), unicode.ToUpper(r)} {
+		if lo <= c && c <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// posixClasses are the [:name:] character classes usable inside brackets.
+var posixClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl":  unicode.IsControl,
+	"digit":  func(r rune) bool { return '0' <= r && r <= '9' },
+	"graph":  func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	"lower":  unicode.IsLower,
+	"print":  unicode.IsPrint,
+	"punct":  func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) },
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+}
+
+// matchClass matches r against the bracket expression at the start of
+// pattern, returning whether it matched and the expression's length. ok is
+// false if the expression is unterminated or names an unknown class.
+func matchClass(pattern string, r rune) (matched bool, n int, ok bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+
+	// A "]" right after the opening bracket is a literal
+	for first := true; ; first = false {
+		if i >= len(pattern) {
+			return false, 0, false
+		}
+		if pattern[i] == ']' && !first {
+			i++
+			break
+		}
+
+		if strings.HasPrefix(pattern[i:], "[:") {
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				class, known := posixClasses[pattern[i+2:i+2+end]]
+				if !known {
+					return false, 0, false
+				}
+				if class(r) {
+					matched = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+
+		lo, w := readLiteral(pattern[i:])
+		i += w
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			hi, w = readLiteral(pattern[i+1:])
+			i += 1 + w
+		}
+		if inRange(r, lo, hi) {
+			matched = true
+		}
+	}
+
+	return matched != negate, i, true
+}
+
+// trimTrailingSpace removes trailing spaces and tabs from a gitignore line
+// unless they are escaped with a backslash.
+func trimTrailingSpace(line string) string {
+	for len(line) > 0 {
+		last := line[len(line)-1]
+		if last != ' ' && last != '\t' {
+			break
+		}
+		if len(line) > 1 && line[len(line)-2] == '\\' {
+			break
+		}
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -0,0 +1,249 @@
+package main
+
+import "testing"
+
+func TestMatchSegment(t *testing.T) {
+	defer func(v bool) { ignoreCase = v }(ignoreCase)
+	ignoreCase = false
+
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		// Literals
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"foo", "foobar", false},
+		{"", "", true},
+		{"", "foo", false},
+
+		// Single-character wildcard
+		{"???", "foo", true},
+		{"??", "foo", false},
+		{"f?o", "fxo", true},
+		{"?", "é", true},
+
+		// Star
+		{"*", "foo", true},
+		{"*", "", true},
+		{"f*", "foo", true},
+		{"*o", "foo", true},
+		{"*f", "foo", false},
+		{"f*o*r", "foobar", true},
+		{"*.log", ".log", true},
+		{"*.log", "debug.log.gz", false},
+		{"*.log*", "debug.log.gz", true},
+		{"**", "foo", true},
+		{"foo**bar", "fooxbar", true},
+		{"a*b*c", "abbbcbc", true},
+		{"a*b*c", "abbbcbd", false},
+		{"*é*", "café", true},
+
+		// Escapes
+		{`\*`, "*", true},
+		{`\*`, "foo", false},
+		{`\?`, "?", true},
+		{`\?`, "x", false},
+		{`\[ab]`, "[ab]", true},
+		{`\[ab]`, "a", false},
+		{`\#notes`, "#notes", true},
+		{`\!important`, "!important", true},
+		{`foo\ `, "foo ", true},
+		{`foo\`, `foo\`, true},
+		{`\a`, "a", true},
+
+		// Bracket expressions
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[a-c]", "b", true},
+		{"[a-c]", "B", false},
+		{"[a-cx-z]", "y", true},
+		{"[!a-c]", "d", true},
+		{"[!a-c]", "a", false},
+		{"[^a-c]", "d", true},
+		{"[^a-c]", "b", false},
+		{"[]]", "]", true},
+		{"[]a]", "a", true},
+		{"[!]]", "]", false},
+		{"[!]]", "a", true},
+		{"[a-]", "-", true},
+		{"[-a]", "-", true},
+		{`[\]]`, "]", true},
+		{`[\-]`, "-", true},
+		{`[a\-z]`, "b", false},
+		{"file[0-9].txt", "file7.txt", true},
+		{"file[0-9].txt", "fileA.txt", false},
+		{"[é]", "é", true},
+
+		// Character classes
+		{"[[:digit:]]", "5", true},
+		{"[[:digit:]]", "a", false},
+		{"[[:alpha:]]", "a", true},
+		{"[[:alnum:]_]", "_", true},
+		{"[[:upper:]]", "A", true},
+		{"[[:upper:]]", "a", false},
+		{"[[:lower:]]", "a", true},
+		{"[[:space:]]", " ", true},
+		{"[[:blank:]]", "\t", true},
+		{"[[:punct:]]", "$", true},
+		{"[[:xdigit:]]", "F", true},
+		{"[[:xdigit:]]", "g", false},
+		{"[![:digit:]]", "a", true},
+		{"[[:digit:][:upper:]]", "Q", true},
+		{"[[:nope:]]", "a", false},
+
+		// Unterminated brackets match literally
+		{"[abc", "[abc", true},
+		{"[abc", "a", false},
+		{"[", "[", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"_"+tt.name, func(t *testing.T) {
+			if got := matchSegment(tt.pattern, tt.name); got != tt.expected {
+				t.Errorf("matchSegment(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMatchPatternGitignore(t *testing.T) {
+	defer func(v bool) { ignoreCase = v }(ignoreCase)
+	ignoreCase = false
+
+	tests := []struct {
+		relPath  string
+		pattern  string
+		isDir    bool
+		expected bool
+	}{
+		// Unanchored names match at any depth
+		{"foo", "foo", false, true},
+		{"a/b/foo", "foo", false, true},
+		{"a/foo/b", "foo", false, true},
+		{"afoo", "foo", false, false},
+		{"a/b/c.txt", "*.txt", false, true},
+		{"a/b.txt/c", "*.txt", false, true},
+
+		// Directory-only patterns
+		{"build", "build/", true, true},
+		{"build", "build/", false, false},
+		{"src/build", "build/", true, true},
+		{"build/out.o", "build/", false, true},
+		{"docs/build", "docs/build/", true, true},
+		{"docs/build", "docs/build/", false, false},
+
+		// Anchored patterns
+		{"TODO", "/TODO", false, true},
+		{"src/TODO", "/TODO", false, false},
+		{"doc/frotz", "doc/frotz", true, true},
+		{"a/doc/frotz", "doc/frotz", true, false},
+		{"doc/frotz/x", "/doc/frotz", false, true},
+		{"foo/bar", "foo/*", false, true},
+		{"foo/bar/baz", "foo/*", false, true},
+		{"foo", "foo/*", true, false},
+		{"x/foo/bar", "foo/*", false, false},
+		{"a/b", "a/?", false, true},
+		{"a/bc", "a/?", false, false},
+
+		// Leading **
+		{"foo", "**/foo", false, true},
+		{"a/b/foo", "**/foo", false, true},
+		{"a/foo/bar", "**/foo/bar", false, true},
+		{"foo/bar", "**/foo/bar", false, true},
+		{"foo/baz", "**/foo/bar", false, false},
+		{"src/test/foo.go", "**/test", false, true},
+
+		// Trailing **
+		{"docs/a", "docs/**", false, true},
+		{"docs/a/b/c", "docs/**", false, true},
+		{"docs", "docs/**", true, false},
+		{"src/docs/a", "docs/**", false, false},
+
+		// Middle **
+		{"a/b", "a/**/b", false, true},
+		{"a/x/b", "a/**/b", false, true},
+		{"a/x/y/b", "a/**/b", false, true},
+		{"a/x/y/c", "a/**/b", false, false},
+		{"src/pkg/testdata", "src/**/testdata", true, true},
+		{"src/testdata/file.json", "src/**/testdata", false, true},
+		{"lib/testdata", "src/**/testdata", true, false},
+		{"a/x/b/y/c", "a/**/b/**/c", false, true},
+
+		// ** not a whole segment acts like *
+		{"foo/abar", "foo/**bar", false, true},
+		{"foo/x/bar", "foo/**bar", false, false},
+
+		// Classes and escapes in paths
+		{"logs/2024-01.txt", "logs/[0-9][0-9][0-9][0-9]-*.txt", false, true},
+		{"logs/old-01.txt", "logs/[0-9][0-9][0-9][0-9]-*.txt", false, false},
+		{"a/*star", `\*star`, false, true},
+		{"a/xstar", `\*star`, false, false},
+		{"#notes", `\#notes`, false, true},
+
+		// Wildcards never cross a slash
+		{"a/b", "a*b", false, false},
+		{"a/b", "a?b", false, false},
+		{"a/b", "a[/]b", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.relPath+"_"+tt.pattern, func(t *testing.T) {
+			got := matchPattern(tt.relPath, tt.pattern, tt.isDir)
+			if got != tt.expected {
+				t.Errorf("matchPattern(%q, %q, %v) = %v, want %v",
+					tt.relPath, tt.pattern, tt.isDir, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrimTrailingSpace(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"foo", "foo"},
+		{"foo  ", "foo"},
+		{"foo\t", "foo"},
+		{`foo\ `, `foo\ `},
+		{`foo\  `, `foo\ `},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := trimTrailingSpace(tt.line); got != tt.expected {
+			t.Errorf("trimTrailingSpace(%q) = %q, want %q", tt.line, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchPatternIgnoreCase(t *testing.T) {
+	defer func(v bool) { ignoreCase = v }(ignoreCase)
+
+	tests := []struct {
+		pattern  string
+		relPath  string
+		expected bool
+	}{
+		{"thumbs.db", "Thumbs.db", true},
+		{"*.LOG", "sub/debug.log", true},
+		{"Build/", "build/out.o", true},
+		{"[a-c]x", "Bx", true},
+		{"[A-C]x", "bx", true},
+		{"[a-c]x", "Dx", false},
+		{"thumbs.db", "thumbs.dbx", false},
+	}
+
+	for _, tt := range tests {
+		ignoreCase = true
+		if got := matchPattern(tt.relPath, tt.pattern, false); got != tt.expected {
+			t.Errorf("matchPattern(%q, %q) ignoring case = %v, want %v", tt.relPath, tt.pattern, got, tt.expected)
+		}
+		ignoreCase = false
+		if tt.expected && matchPattern(tt.relPath, tt.pattern, false) {
+			t.Errorf("matchPattern(%q, %q) should not match case-sensitively", tt.relPath, tt.pattern)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build darwin
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sysClonefileat = 462 // SYS_clonefileat
+	atFDCWD        = -2
+)
+
+// nativeCopy clones src to the new file dest (clonefileat), which on APFS
+// shares the data blocks until either file changes. It reports false if
+// the file has to be copied instead, e.g. across volumes.
+func nativeCopy(src, dest string) bool {
+	srcPtr, err := syscall.BytePtrFromString(src)
+	if err != nil {
+		return false
+	}
+	destPtr, err := syscall.BytePtrFromString(dest)
+	if err != nil {
+		return false
+	}
+	fdcwd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysClonefileat, uintptr(fdcwd), uintptr(unsafe.Pointer(srcPtr)), uintptr(fdcwd), uintptr(unsafe.Pointer(destPtr)), 0, 0)
+	return errno == 0
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !windows
+
+package main
+
+// nativeCopy leaves copying to copyContent, where io.Copy between two
+// files already uses copy_file_range or sendfile on Linux.
+func nativeCopy(src, dest string) bool {
+	return false
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const copyFileFailIfExists = 0x00000001
+
+var procCopyFileExW = syscall.NewLazyDLL("kernel32.dll").NewProc("CopyFileExW")
+
+// nativeCopy copies src to the new file dest with CopyFileExW, which lets
+// the filesystem or a network share copy the data itself. It reports false
+// if the file has to be copied byte by byte instead.
+func nativeCopy(src, dest string) bool {
+	srcPtr, err := syscall.UTF16PtrFromString(src)
+	if err != nil {
+		return false
+	}
+	destPtr, err := syscall.UTF16PtrFromString(dest)
+	if err != nil {
+		return false
+	}
+	r, _, _ := procCopyFileExW.Call(uintptr(unsafe.Pointer(srcPtr)), uintptr(unsafe.Pointer(destPtr)), 0, 0, 0, copyFileFailIfExists)
+	if r == 0 {
+		// Don't leave a partial copy for the fallback to trip over
+		_ = os.Remove(dest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// parseReportOn checks the value of a flag that says which runs are worth
+// reporting.
+func parseReportOn(flag, on string) error {
+	switch on {
+	case "always", "error", "delete":
+		return nil
+	}
+	return fmt.Errorf("unknown %s value: %s (want always, error or delete)", flag, on)
+}
+
+// reportWanted reports whether a run that produced plan and err is worth
+// reporting: every run, only failures, or failures and runs that deleted
+// something.
+func reportWanted(on string, plan *syncPlan, err error) bool {
+	switch on {
+	case "error":
+		return err != nil
+	case "delete":
+		return err != nil || (plan != nil && plan.Stats.FilesDeleted > 0)
+	}
+	return true
+}
+
+// defaultNotifyTemplate is the chat message for a run unless
+// --notify-template gives another.
+const defaultNotifyTemplate = `{{if .Success}}rift synced {{.Dest}}: {{.Copied}} copied ({{.Bytes}} bytes), {{.Deleted}} deleted in {{.Duration}}` +
+	`{{else}}rift: sync to {{.Dest}} failed after {{.Duration}}: {{.Error}}{{end}}`
+
+// notifySettings say which Slack or Discord webhooks to post a message to
+// after a sync, and when.
+type notifySettings struct {
+	urls     []string
+	on       string // "always", "error" or "delete"
+	template *template.Template
+}
+
+// notifyData is what a --notify-template can refer to.
+type notifyData struct {
+	Source   string
+	Dest     string
+	Checked  int
+	Copied   int
+	Deleted  int
+	Bytes    int64
+	Duration time.Duration
+	Success  bool
+	Error    string
+}
+
+// parseNotifyTemplate parses a --notify-template, or the default one.
+func parseNotifyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	tmpl, err := template.New("notify").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --notify-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// notifyWebhooks posts a message about the run to every webhook when the
+// settings ask for one. A webhook that can't be reached is worth a warning
+// but doesn't fail the sync.
+func notifyWebhooks(n notifySettings, dest string, plan *syncPlan, runErr error, elapsed time.Duration) {
+	if len(n.urls) == 0 || !reportWanted(n.on, plan, runErr) {
+		return
+	}
+	data := notifyData{Dest: dest, Duration: elapsed.Round(time.Millisecond), Success: runErr == nil}
+	if plan != nil {
+		s := plan.Stats
+		data.Source, data.Checked, data.Copied, data.Deleted, data.Bytes = plan.Src, s.FilesChecked, s.FilesCopied, s.FilesDeleted, s.BytesCopied
+	}
+	if runErr != nil {
+		data.Error = runErr.Error()
+	}
+	tmpl := n.template
+	if tmpl == nil {
+		tmpl, _ = parseNotifyTemplate("")
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, data); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: formatting notification: %v\n", err)
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, u := range n.urls {
+		if err := postWebhook(client, u, text.String()); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: notifying %s: %v\n", redactURL(u), err)
+		}
+	}
+}
+
+// postWebhook sends text to a Slack or Discord incoming webhook, which
+// differ only in the name of the field holding the message.
+func postWebhook(client *http.Client, webhook, text string) error {
+	body, err := json.Marshal(webhookPayload(webhook, text))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Leave out the URL, which holds the webhook's secret
+		if uerr, ok := err.(*url.Error); ok {
+			return uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// webhookPayload is the JSON body of a message: Discord's for a Discord
+// webhook, Slack's (which Mattermost and others accept too) for anything
+// else.
+func webhookPayload(webhook, text string) map[string]string {
+	if u, err := url.Parse(webhook); err == nil {
+		host := u.Hostname()
+		if host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com") {
+			return map[string]string{"content": text}
+		}
+	}
+	return map[string]string{"text": text}
+}
+
+// redactURL drops the path of a webhook URL for warnings, since the path
+// is the secret.
+func redactURL(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil || u.Host == "" {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host + "/..."
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReportWanted(t *testing.T) {
+	clean := &syncPlan{}
+	deleted := &syncPlan{Stats: syncStats{FilesDeleted: 2}}
+	failed := errors.New("disk full")
+
+	tests := []struct {
+		on   string
+		plan *syncPlan
+		err  error
+		want bool
+	}{
+		{"always", clean, nil, true},
+		{"error", clean, nil, false},
+		{"error", deleted, nil, false},
+		{"error", nil, failed, true},
+		{"delete", clean, nil, false},
+		{"delete", deleted, nil, true},
+		{"delete", nil, failed, true},
+	}
+	for _, tt := range tests {
+		if got := reportWanted(tt.on, tt.plan, tt.err); got != tt.want {
+			t.Errorf("reportWanted(%s), plan %+v, err %v = %v, want %v", tt.on, tt.plan, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNotifyWebhooks(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	plan := &syncPlan{Src: "/src/app", Stats: syncStats{FilesCopied: 3, BytesCopied: 42, FilesDeleted: 1}}
+	n := notifySettings{urls: []string{server.URL}, on: "always"}
+	notifyWebhooks(n, "/backup/app", plan, nil, 2*time.Second)
+	if want := "rift synced /backup/app: 3 copied (42 bytes), 1 deleted in 2s"; got["text"] != want {
+		t.Errorf("message = %q, want %q", got["text"], want)
+	}
+
+	tmpl, err := parseNotifyTemplate("{{.Dest}}: {{if .Success}}ok{{else}}FAILED: {{.Error}}{{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.template = tmpl
+	notifyWebhooks(n, "/backup/app", nil, errors.New("disk full"), time.Second)
+	if want := "/backup/app: FAILED: disk full"; got["text"] != want {
+		t.Errorf("message = %q, want %q", got["text"], want)
+	}
+
+	got = nil
+	n.on = "error"
+	notifyWebhooks(n, "/backup/app", plan, nil, time.Second)
+	if got != nil {
+		t.Error("--notify-on error should not post about a successful run")
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	if p := webhookPayload("https://discord.com/api/webhooks/1/abc", "hi"); p["content"] != "hi" {
+		t.Errorf("Discord payload = %v", p)
+	}
+	if p := webhookPayload("https://hooks.slack.com/services/T/B/X", "hi"); p["text"] != "hi" {
+		t.Errorf("Slack payload = %v", p)
+	}
+}
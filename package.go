@@ -0,0 +1,359 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pkgmeta holds the subset of a .pkgmeta packaging manifest that rift
+// understands.
+type pkgmeta struct {
+	PackageAs   string
+	Ignore      []string
+	MoveFolders []moveFolder
+	Externals   []external
+}
+
+// moveFolder relocates a folder inside the package, e.g. to ship a module as
+// its own top-level addon.
+type moveFolder struct {
+	From string
+	To   string
+}
+
+// external is a git repository fetched into the package at Path.
+type external struct {
+	Path string
+	URL  string
+	Tag  string
+}
+
+func runPackage(args []string) error {
+	var version string
+	var outDir string
+	var projectName string
+	var excludePatterns []string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--version":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--version requires a version argument")
+			}
+			i++
+			version = args[i]
+		case "--out":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--out requires a path argument")
+			}
+			i++
+			outDir = args[i]
+		case "--name":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--name requires a name argument")
+			}
+			i++
+			projectName = args[i]
+		case "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--exclude requires a pattern argument")
+			}
+			i++
+			excludePatterns = append(excludePatterns, args[i])
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+		}
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	meta, err := parsePkgmeta(filepath.Join(srcPath, ".pkgmeta"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading .pkgmeta: %w", err)
+	}
+	if meta == nil {
+		meta = &pkgmeta{}
+	}
+
+	// --name wins over package-as, which wins over the directory name
+	if projectName == "" {
+		projectName = meta.PackageAs
+	}
+	if projectName == "" {
+		projectName = filepath.Base(srcPath)
+	}
+
+	if version == "" {
+		if version, err = gitVersion(srcPath); err != nil {
+			return fmt.Errorf("--version not given and git describe failed: %w", err)
+		}
+	}
+
+	// Both name the zip and the name is its top-level folder
+	for _, part := range []string{projectName, version} {
+		if strings.ContainsAny(part, `/\`) || !filepath.IsLocal(part) {
+			return fmt.Errorf("%q can't be used in a package file name", part)
+		}
+	}
+
+	if outDir == "" {
+		outDir = ".release"
+	}
+	zipPath, err := filepath.Abs(filepath.Join(outDir, projectName+"-"+version+".zip"))
+	if err != nil {
+		return err
+	}
+
+	// Never package the manifest itself, nor earlier packages
+	rules := loadRules(srcPath, "", excludePatterns)
+	rules = append(rules, newRules("default", ".pkgmeta")...)
+	rules = append(rules, newRules(".pkgmeta", meta.Ignore...)...)
+	if rel, err := filepath.Rel(srcPath, filepath.Dir(zipPath)); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		rules = append(rules, newRules("--out", "/"+filepath.ToSlash(rel))...)
+	}
+	rules = append(rules, newRules("--out", "/"+filepath.Base(zipPath))...)
+
+	if err := buildPackage(srcPath, zipPath, projectName, rules, meta); err != nil {
+		return err
+	}
+	fmt.Println(zipPath)
+	return nil
+}
+
+// gitVersion derives a package version from the closest git tag.
+func gitVersion(dir string) (string, error) {
+	cmd := exec.Command("git", "describe", "--tags", "--always")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parsePkgmeta reads the package-as, ignore, move-folders and externals keys
+// of a .pkgmeta file. Only the YAML shapes used by those keys are supported;
+// anything else is ignored.
+func parsePkgmeta(path string) (*pkgmeta, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	meta := &pkgmeta{}
+	var section string
+	var current *external
+	entryIndent := -1
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " \t"))
+
+		// Top-level key
+		if indent == 0 {
+			key, value := splitYAMLKey(line)
+			section = key
+			current = nil
+			entryIndent = -1
+			if key == "package-as" {
+				meta.PackageAs = value
+			}
+			continue
+		}
+
+		switch section {
+		case "ignore":
+			if strings.HasPrefix(line, "-") {
+				meta.Ignore = append(meta.Ignore, unquoteYAML(strings.TrimSpace(line[1:])))
+			}
+		case "move-folders":
+			if from, to := splitYAMLKey(line); from != "" && to != "" {
+				meta.MoveFolders = append(meta.MoveFolders, moveFolder{From: from, To: to})
+			}
+		case "externals":
+			key, value := splitYAMLKey(line)
+			if entryIndent < 0 {
+				entryIndent = indent
+			}
+			if indent == entryIndent {
+				meta.Externals = append(meta.Externals, external{Path: key, URL: value})
+				current = &meta.Externals[len(meta.Externals)-1]
+				continue
+			}
+			if current == nil {
+				continue
+			}
+			switch key {
+			case "url":
+				current.URL = value
+			case "tag":
+				current.Tag = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, ext := range meta.Externals {
+		if ext.URL == "" {
+			return nil, fmt.Errorf("external %s has no url", ext.Path)
+		}
+	}
+	for _, m := range meta.MoveFolders {
+		if !filepath.IsLocal(filepath.FromSlash(m.To)) {
+			return nil, fmt.Errorf("move-folders target %q must be inside the package", m.To)
+		}
+	}
+	return meta, nil
+}
+
+// splitYAMLKey splits "key: value" (or "key:") into its unquoted parts.
+func splitYAMLKey(line string) (string, string) {
+	if strings.HasSuffix(line, ":") {
+		return unquoteYAML(strings.TrimSuffix(line, ":")), ""
+	}
+	key, value, found := strings.Cut(line, ": ")
+	if !found {
+		return "", ""
+	}
+	return unquoteYAML(strings.TrimSpace(key)), unquoteYAML(strings.TrimSpace(value))
+}
+
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// buildPackage writes the filtered source tree to zipPath under a top-level
+// folder called name, followed by any externals.
+func buildPackage(src, zipPath, name string, rules []rule, meta *pkgmeta) (err error) {
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		// Don't leave a half-written package behind
+		if err != nil {
+			_ = os.Remove(zipPath)
+		}
+	}()
+
+	zw := zip.NewWriter(file)
+	if err := addTree(zw, src, name, rules, meta.MoveFolders); err != nil {
+		return fmt.Errorf("packaging source: %w", err)
+	}
+
+	for _, ext := range meta.Externals {
+		if err := addExternal(zw, name, ext, meta.MoveFolders); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// addExternal clones ext into a temporary directory and adds it to the
+// package. A tag of "latest" (or none) uses the default branch. The path
+// has to stay inside the package folder.
+func addExternal(zw *zip.Writer, name string, ext external, moves []moveFolder) error {
+	if !filepath.IsLocal(filepath.FromSlash(ext.Path)) {
+		return fmt.Errorf("external %q: path must be inside the package", ext.Path)
+	}
+
+	tmp, err := os.MkdirTemp("", "rift-external-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ext.Tag != "" && ext.Tag != "latest" {
+		args = append(args, "--branch", ext.Tag)
+	}
+	args = append(args, "--", ext.URL, tmp)
+	if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("fetching external %s: %v: %s", ext.Path, err, strings.TrimSpace(string(out)))
+	}
+
+	if err := addTree(zw, tmp, path.Join(name, ext.Path), newRules("default", ".git"), moves); err != nil {
+		return fmt.Errorf("packaging external %s: %w", ext.Path, err)
+	}
+	return nil
+}
+
+// addTree adds every non-excluded file below root to zw, prefixing entry
+// names with prefix and applying move-folders.
+func addTree(zw *zip.Writer, root, prefix string, rules []rule, moves []moveFolder) error {
+	return walkSource(root, rules, func(relPath string, info fs.FileInfo) error {
+		// Directories are implied by the file entries
+		if info.IsDir() {
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = movePath(path.Join(prefix, filepath.ToSlash(relPath)), moves)
+		header.Method = zip.Deflate
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(root, relPath))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// movePath applies the first matching move-folders rule to a zip entry name.
+func movePath(name string, moves []moveFolder) string {
+	for _, m := range moves {
+		from := strings.Trim(m.From, "/")
+		if name == from || strings.HasPrefix(name, from+"/") {
+			return path.Join(m.To, strings.TrimPrefix(name, from))
+		}
+	}
+	return name
+}
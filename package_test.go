@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParsePkgmeta(t *testing.T) {
+	dir := t.TempDir()
+	pkgmetaPath := filepath.Join(dir, ".pkgmeta")
+
+	content := `# Packager settings
+package-as: MyAddon
+
+externals:
+  Libs/LibStub: https://example.com/LibStub.git
+  Libs/AceDB-3.0:
+    url: https://example.com/AceDB.git
+    tag: v1.2
+
+move-folders:
+  MyAddon/Modules/Foo: MyAddon_Foo
+
+ignore:
+  - README.md
+  - "*.psd" # artwork sources
+`
+	if err := os.WriteFile(pkgmetaPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := parsePkgmeta(pkgmetaPath)
+	if err != nil {
+		t.Fatalf("parsePkgmeta() error = %v", err)
+	}
+
+	if meta.PackageAs != "MyAddon" {
+		t.Errorf("PackageAs = %q, want %q", meta.PackageAs, "MyAddon")
+	}
+
+	expectedIgnore := []string{"README.md", "*.psd"}
+	if len(meta.Ignore) != len(expectedIgnore) {
+		t.Fatalf("got %d ignore patterns, want %d", len(meta.Ignore), len(expectedIgnore))
+	}
+	for i, p := range expectedIgnore {
+		if meta.Ignore[i] != p {
+			t.Errorf("Ignore[%d] = %q, want %q", i, meta.Ignore[i], p)
+		}
+	}
+
+	expectedExternals := []external{
+		{Path: "Libs/LibStub", URL: "https://example.com/LibStub.git"},
+		{Path: "Libs/AceDB-3.0", URL: "https://example.com/AceDB.git", Tag: "v1.2"},
+	}
+	if len(meta.Externals) != len(expectedExternals) {
+		t.Fatalf("got %d externals, want %d", len(meta.Externals), len(expectedExternals))
+	}
+	for i, ext := range expectedExternals {
+		if meta.Externals[i] != ext {
+			t.Errorf("Externals[%d] = %+v, want %+v", i, meta.Externals[i], ext)
+		}
+	}
+
+	if len(meta.MoveFolders) != 1 || meta.MoveFolders[0] != (moveFolder{From: "MyAddon/Modules/Foo", To: "MyAddon_Foo"}) {
+		t.Errorf("MoveFolders = %+v, want [{MyAddon/Modules/Foo MyAddon_Foo}]", meta.MoveFolders)
+	}
+}
+
+func TestMovePath(t *testing.T) {
+	moves := []moveFolder{{From: "MyAddon/Modules/Foo", To: "MyAddon_Foo"}}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"MyAddon/Modules/Foo/Foo.lua", "MyAddon_Foo/Foo.lua"},
+		{"MyAddon/Modules/Foo", "MyAddon_Foo"},
+		{"MyAddon/Modules/FooBar/Bar.lua", "MyAddon/Modules/FooBar/Bar.lua"},
+		{"MyAddon/Core.lua", "MyAddon/Core.lua"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := movePath(tt.name, moves); got != tt.expected {
+				t.Errorf("movePath(%q) = %q, want %q", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunPackage(t *testing.T) {
+	srcDir := t.TempDir()
+
+	files := map[string]string{
+		"MyAddon.toc":          "## Title: MyAddon",
+		"Core.lua":             "-- core",
+		"Modules/Foo/Foo.lua":  "-- foo",
+		"debug.log":            "logs",
+		"art/icon.psd":         "psd",
+		".pkgmeta":             "package-as: MyAddon\nmove-folders:\n  MyAddon/Modules/Foo: MyAddon_Foo\nignore:\n  - art\n",
+		".gitignore":           "*.log\n",
+		".release/old-1.0.zip": "stale",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"package", "--version", "1.2.0"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(srcDir, ".release", "MyAddon-1.2.0.zip"))
+	if err != nil {
+		t.Fatalf("opening package: %v", err)
+	}
+	defer zr.Close()
+
+	var got []string
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+	}
+	sort.Strings(got)
+
+	expected := []string{
+		"MyAddon/.gitignore",
+		"MyAddon/Core.lua",
+		"MyAddon/MyAddon.toc",
+		"MyAddon_Foo/Foo.lua",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("package contains %v, want %v", got, expected)
+	}
+	for i, name := range expected {
+		if got[i] != name {
+			t.Errorf("entry[%d] = %q, want %q", i, got[i], name)
+		}
+	}
+}
+
+func TestRunPackageVersionFlagMissingArg(t *testing.T) {
+	err := run([]string{"package", "--version"})
+	if err == nil {
+		t.Error("expected error when --version has no argument")
+	}
+}
+
+func TestAddExternalRejectsEscapingPath(t *testing.T) {
+	zw := zip.NewWriter(io.Discard)
+	for _, p := range []string{"../x", "/abs/lib", ""} {
+		err := addExternal(zw, "MyAddon", external{Path: p, URL: "https://example.com/lib.git"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "inside the package") {
+			t.Errorf("addExternal(%q) error = %v, want a path error", p, err)
+		}
+	}
+}
+
+func TestRunPackageRejectsEscapingNames(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "Core.lua"), []byte("-- core"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	for _, args := range [][]string{
+		{"--version", "../1.0"},
+		{"--version", "1/0"},
+		{"--version", `1\0`},
+		{"--version", "1.0", "--name", ".."},
+	} {
+		if err := run(append([]string{"package"}, args...)); err == nil {
+			t.Errorf("package %v should fail", args)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(srcDir, ".pkgmeta"), []byte("move-folders:\n  Core: ../Escaped\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"package", "--version", "1.0"}); err == nil || !strings.Contains(err.Error(), "inside the package") {
+		t.Errorf("package with an escaping move-folders target error = %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// pingURL returns the URL to ping after a run: base itself for a success,
+// or base with /fail appended for a failure, as healthchecks.io and
+// compatible services expect.
+func pingURL(base string, runErr error) string {
+	if runErr == nil {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/fail"
+}
+
+// pingHealthcheck tells a dead-man's-switch service that the run finished,
+// posting the summary so it shows up in the service's log. The service
+// raises the alarm when pings stop, so a ping that can't be sent is only
+// worth a warning.
+func pingHealthcheck(base string, plan *syncPlan, runErr error) {
+	if base == "" {
+		return
+	}
+	var body strings.Builder
+	if plan != nil {
+		body.WriteString(plan.Stats.summary() + "\n")
+	}
+	if runErr != nil {
+		fmt.Fprintf(&body, "error: %v\n", runErr)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(pingURL(base, runErr), "text/plain; charset=utf-8", strings.NewReader(body.String()))
+	if err != nil {
+		// Leave out the URL, which identifies the check
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		fmt.Fprintf(os.Stderr, "warning: pinging %s: %v\n", redactURL(base), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Fprintf(os.Stderr, "warning: pinging %s: %s\n", redactURL(base), resp.Status)
+	}
+}
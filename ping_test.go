@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPingHealthcheck(t *testing.T) {
+	var gotPath, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(body)
+	}))
+	defer server.Close()
+
+	plan := &syncPlan{Stats: syncStats{Source: "/src/app", Dest: "/backup/app", FilesCopied: 2}}
+	pingHealthcheck(server.URL+"/ping/abc", plan, nil)
+	if gotPath != "/ping/abc" || !strings.Contains(gotBody, "2 copied") {
+		t.Errorf("success ping went to %q with %q", gotPath, gotBody)
+	}
+
+	pingHealthcheck(server.URL+"/ping/abc/", nil, errors.New("disk full"))
+	if gotPath != "/ping/abc/fail" || !strings.Contains(gotBody, "error: disk full") {
+		t.Errorf("failure ping went to %q with %q", gotPath, gotBody)
+	}
+}
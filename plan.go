@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// planFile is the JSON form of a plan, written by rift plan --output json
+// and executed by rift apply. Paths use forward slashes.
+type planFile struct {
+	Source     string            `json:"source"`
+	Dest       string            `json:"dest"`
+	Excludes   []string          `json:"excludes,omitempty"`
+	Presets    []string          `json:"presets,omitempty"`
+	Includes   []string          `json:"includes,omitempty"`
+	Paths      []string          `json:"paths,omitempty"`
+	Options    syncOptions       `json:"options"`
+	Operations []operation       `json:"operations"`
+	Files      []string          `json:"files"`
+	IDs        map[string]fileID `json:"ids,omitempty"`
+}
+
+func runPlan(args []string) error {
+	output := "text"
+	color := "auto"
+
+	// Pull out the plan-only flags; everything else is a sync flag
+	var syncFlags []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--output":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--output requires a format argument")
+			}
+			i++
+			output = args[i]
+		case "--color":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--color requires auto, always or never")
+			}
+			i++
+			color = args[i]
+		default:
+			syncFlags = append(syncFlags, args[i])
+		}
+	}
+	if output != "text" && output != "json" && output != "diff" {
+		return fmt.Errorf("unknown output format: %s (want text, json or diff)", output)
+	}
+	if err := parseColorMode(color); err != nil {
+		return err
+	}
+
+	parsed, err := parseSyncArgs(syncFlags)
+	if err != nil {
+		return err
+	}
+	if parsed.help {
+		printUsage()
+		return nil
+	}
+	if parsed.link {
+		return fmt.Errorf("--link has nothing to plan")
+	}
+
+	srcPath, fullDest, err := parsed.resolve()
+	if err != nil {
+		return err
+	}
+	if parsed.opts.Background {
+		enterBackground()
+	}
+	if target, ok := readLink(fullDest); ok && target == srcPath {
+		return fmt.Errorf("%s is linked to the source; sync without --link to replace it", fullDest)
+	}
+
+	dir, cleanup, err := sourceDir(srcPath, &parsed.opts)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	parsed.opts.loadLastSync(fullDest)
+
+	plan, err := buildPlan(dir, fullDest, parsed.rules(dir), parsed.opts)
+	if err != nil {
+		return err
+	}
+	// A --ref export is temporary; apply exports the revision again
+	plan.setSource(srcPath)
+
+	switch output {
+	case "json":
+		return writePlanJSON(os.Stdout, plan, parsed)
+	case "diff":
+		return printPlanDiff(os.Stdout, plan, useColor(color, os.Stdout))
+	}
+	if parsed.opts.Itemize {
+		return printItemized(os.Stdout, plan)
+	}
+	return printPlan(os.Stdout, plan)
+}
+
+func runApply(args []string) error {
+	var planPath string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") && args[i] != "-" {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+			planPath = args[i]
+		}
+	}
+
+	if planPath == "" {
+		return fmt.Errorf("apply requires a plan file argument (or - for stdin)")
+	}
+
+	var r io.Reader = os.Stdin
+	if planPath != "-" {
+		file, err := os.Open(planPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	pf, err := readPlanJSON(r)
+	if err != nil {
+		return fmt.Errorf("reading plan: %w", err)
+	}
+	plan := pf.plan()
+	if pf.Options.Background {
+		enterBackground()
+	}
+
+	if _, err := unlinkTree(plan.Src, plan.Dest); err != nil {
+		return err
+	}
+	if err := checkInterrupted(plan.Dest, true); err != nil {
+		return err
+	}
+	dir, cleanup, err := sourceDir(plan.Src, &pf.Options)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	plan.Root = dir
+	if err := executePlan(plan, pf.Options); err != nil {
+		return err
+	}
+	recordPlan(&targetState{Source: plan.Src, Dest: plan.Dest, Excludes: pf.Excludes, Presets: pf.Presets, Includes: pf.Includes, Options: pf.Options}, plan)
+	return nil
+}
+
+// printPlan writes a human-readable plan followed by a summary line.
+func printPlan(w io.Writer, plan *syncPlan) error {
+	var copyBytes int64
+	for _, op := range plan.Ops {
+		var err error
+		path := filepath.ToSlash(op.Path)
+		switch op.Kind {
+		case opMkdir:
+			_, err = fmt.Fprintf(w, "mkdir   %s/\n", path)
+		case opCopy:
+			copyBytes += op.Size
+			if op.Src != "" {
+				_, err = fmt.Fprintf(w, "copy    %s (from %s, %d bytes)\n", path, filepath.ToSlash(op.Src), op.Size)
+			} else {
+				_, err = fmt.Fprintf(w, "copy    %s (%d bytes)\n", path, op.Size)
+			}
+		case opLink:
+			_, err = fmt.Fprintf(w, "link    %s => %s\n", path, filepath.ToSlash(op.Target))
+		case opDelete:
+			_, err = fmt.Fprintf(w, "delete  %s\n", path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d operations, %d bytes to copy\n", len(plan.Ops), copyBytes)
+	return err
+}
+
+// printPlanDiff writes the plan grouped by directory, marking additions
+// with + (green), modifications with ~ (yellow) and deletions with -
+// (red), followed by the same summary line as printPlan.
+func printPlanDiff(w io.Writer, plan *syncPlan, color bool) error {
+	groups := make(map[string][]operation)
+	var dirs []string
+	var copyBytes int64
+	for _, op := range plan.Ops {
+		dir := path.Dir(filepath.ToSlash(op.Path))
+		if _, ok := groups[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		groups[dir] = append(groups[dir], op)
+		if op.Kind == opCopy {
+			copyBytes += op.Size
+		}
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		header := dir + "/"
+		if dir == "." {
+			header = "./"
+		}
+		if _, err := fmt.Fprintln(w, colorize(header, ansiBold, color)); err != nil {
+			return err
+		}
+		for _, op := range groups[dir] {
+			name := path.Base(filepath.ToSlash(op.Path))
+			var line string
+			switch {
+			case op.Kind == opMkdir:
+				line = colorize("  + "+name+"/", ansiGreen, color)
+			case op.Kind == opCopy && op.New:
+				line = colorize(fmt.Sprintf("  + %s (%d bytes)", name, op.Size), ansiGreen, color)
+			case op.Kind == opCopy:
+				line = colorize(fmt.Sprintf("  ~ %s (%d bytes)", name, op.Size), ansiYellow, color)
+			case op.Kind == opLink && op.New:
+				line = colorize(fmt.Sprintf("  + %s => %s", name, filepath.ToSlash(op.Target)), ansiGreen, color)
+			case op.Kind == opLink:
+				line = colorize(fmt.Sprintf("  ~ %s => %s", name, filepath.ToSlash(op.Target)), ansiYellow, color)
+			case op.Kind == opDelete:
+				line = colorize("  - "+name, ansiRed, color)
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d operations, %d bytes to copy\n", len(plan.Ops), copyBytes)
+	return err
+}
+
+func writePlanJSON(w io.Writer, plan *syncPlan, parsed *syncArgs) error {
+	pf := planFile{
+		Source:     plan.Src,
+		Dest:       plan.Dest,
+		Excludes:   parsed.excludePatterns,
+		Presets:    parsed.presets,
+		Includes:   parsed.includes,
+		Options:    parsed.opts,
+		Operations: make([]operation, len(plan.Ops)),
+		Files:      make([]string, len(plan.Files)),
+	}
+	for i, op := range plan.Ops {
+		op.Path = filepath.ToSlash(op.Path)
+		op.Src = filepath.ToSlash(op.Src)
+		op.Target = filepath.ToSlash(op.Target)
+		pf.Operations[i] = op
+	}
+	for i, f := range plan.Files {
+		pf.Files[i] = filepath.ToSlash(f)
+	}
+	for _, p := range parsed.opts.Paths {
+		pf.Paths = append(pf.Paths, filepath.ToSlash(p))
+	}
+	if plan.IDs != nil {
+		pf.IDs = make(map[string]fileID, len(plan.IDs))
+		for p, id := range plan.IDs {
+			pf.IDs[filepath.ToSlash(p)] = id
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(pf)
+}
+
+// readPlanJSON decodes a plan file, rejecting operations that would reach
+// outside the destination.
+func readPlanJSON(r io.Reader) (*planFile, error) {
+	var pf planFile
+	if err := json.NewDecoder(r).Decode(&pf); err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(pf.Source) || !filepath.IsAbs(pf.Dest) {
+		return nil, fmt.Errorf("source and dest must be absolute paths")
+	}
+
+	for i, op := range pf.Operations {
+		switch op.Kind {
+		case opMkdir, opCopy, opDelete, opLink:
+		default:
+			return nil, fmt.Errorf("unknown operation %q", op.Kind)
+		}
+		path := filepath.FromSlash(op.Path)
+		if !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("operation path %q is outside the destination", op.Path)
+		}
+		pf.Operations[i].Path = path
+		if op.Src != "" {
+			src := filepath.FromSlash(op.Src)
+			if !filepath.IsLocal(src) {
+				return nil, fmt.Errorf("operation source %q is outside the source", op.Src)
+			}
+			pf.Operations[i].Src = src
+		}
+		if op.Kind == opLink {
+			target := filepath.FromSlash(op.Target)
+			if !filepath.IsLocal(target) {
+				return nil, fmt.Errorf("link target %q is outside the destination", op.Target)
+			}
+			pf.Operations[i].Target = target
+		}
+	}
+	for i, f := range pf.Files {
+		pf.Files[i] = filepath.FromSlash(f)
+	}
+	if pf.IDs != nil {
+		ids := make(map[string]fileID, len(pf.IDs))
+		for p, id := range pf.IDs {
+			ids[filepath.FromSlash(p)] = id
+		}
+		pf.IDs = ids
+	}
+	for _, p := range pf.Paths {
+		path := filepath.FromSlash(p)
+		if !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("sub-path %q is outside the source", p)
+		}
+		pf.Options.Paths = append(pf.Options.Paths, path)
+	}
+	return &pf, nil
+}
+
+func (pf *planFile) plan() *syncPlan {
+	return &syncPlan{Src: pf.Source, Root: pf.Source, Dest: pf.Dest, Ops: pf.Operations, Files: pf.Files, IDs: pf.IDs}
+}
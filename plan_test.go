@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPlanJSONRoundTrip(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := filepath.Join(t.TempDir(), "App")
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	parsed := &syncArgs{excludePatterns: []string{"*.log"}, presets: []string{"node"}, opts: syncOptions{Manifest: true}}
+	if err := writePlanJSON(&buf, plan, parsed); err != nil {
+		t.Fatalf("writePlanJSON() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `"path": "sub/a.txt"`) {
+		t.Errorf("plan JSON should use slash-separated paths:\n%s", buf.String())
+	}
+
+	pf, err := readPlanJSON(&buf)
+	if err != nil {
+		t.Fatalf("readPlanJSON() error = %v", err)
+	}
+	if !pf.Options.Manifest || len(pf.Excludes) != 1 || len(pf.Presets) != 1 {
+		t.Errorf("options, excludes and presets not preserved: %+v", pf)
+	}
+
+	got := pf.plan()
+	if len(got.Ops) != len(plan.Ops) {
+		t.Fatalf("got %d operations, want %d", len(got.Ops), len(plan.Ops))
+	}
+	for i := range plan.Ops {
+		if got.Ops[i] != plan.Ops[i] {
+			t.Errorf("Ops[%d] = %+v, want %+v", i, got.Ops[i], plan.Ops[i])
+		}
+	}
+
+	// Planning didn't touch the destination; applying does
+	if _, err := os.Stat(destDir); err == nil {
+		t.Fatal("destination should not exist before apply")
+	}
+	if err := executePlan(got, pf.Options); err != nil {
+		t.Fatalf("executePlan() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "sub", "a.txt")); err != nil {
+		t.Error("sub/a.txt should exist after apply")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, manifestName)); err != nil {
+		t.Error("manifest option from the plan should be honoured")
+	}
+}
+
+func TestReadPlanJSONRejectsEscapingPaths(t *testing.T) {
+	dir := t.TempDir()
+	input := `{"source": "` + filepath.ToSlash(dir) + `", "dest": "` + filepath.ToSlash(dir) + `",
+		"operations": [{"op": "delete", "path": "../../etc"}]}`
+
+	if _, err := readPlanJSON(strings.NewReader(input)); err == nil {
+		t.Error("expected error for an operation outside the destination")
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	plan := &syncPlan{Ops: []operation{
+		{Kind: opMkdir, Path: "sub"},
+		{Kind: opCopy, Path: filepath.Join("sub", "a.txt"), Size: 5},
+		{Kind: opDelete, Path: "old.txt"},
+	}}
+
+	var buf bytes.Buffer
+	if err := printPlan(&buf, plan); err != nil {
+		t.Fatalf("printPlan() error = %v", err)
+	}
+
+	expected := "mkdir   sub/\ncopy    sub/a.txt (5 bytes)\ndelete  old.txt\n3 operations, 5 bytes to copy\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("printPlan() = %q, want %q", got, expected)
+	}
+}
+
+func TestRunPlanUnknownOutput(t *testing.T) {
+	if err := run([]string{"plan", "--to", "/tmp", "--output", "yaml"}); err == nil {
+		t.Error("expected error for unknown output format")
+	}
+}
+
+func TestPrintPlanDiff(t *testing.T) {
+	plan := &syncPlan{Ops: []operation{
+		{Kind: opCopy, Path: "top.txt", Size: 3},
+		{Kind: opMkdir, Path: "sub"},
+		{Kind: opCopy, Path: filepath.Join("sub", "a.txt"), Size: 5, New: true},
+		{Kind: opDelete, Path: "old.txt"},
+	}}
+
+	var buf bytes.Buffer
+	if err := printPlanDiff(&buf, plan, false); err != nil {
+		t.Fatalf("printPlanDiff() error = %v", err)
+	}
+	expected := "./\n  ~ top.txt (3 bytes)\n  + sub/\n  - old.txt\nsub/\n  + a.txt (5 bytes)\n4 operations, 8 bytes to copy\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("printPlanDiff() = %q, want %q", got, expected)
+	}
+
+	buf.Reset()
+	if err := printPlanDiff(&buf, plan, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		ansiYellow + "  ~ top.txt (3 bytes)" + ansiReset,
+		ansiGreen + "  + a.txt (5 bytes)" + ansiReset,
+		ansiRed + "  - old.txt" + ansiReset,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("colored diff missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRunPlanUnknownColor(t *testing.T) {
+	if err := run([]string{"plan", "--to", "/tmp", "--output", "diff", "--color", "sometimes"}); err == nil {
+		t.Error("expected error for unknown color mode")
+	}
+}
+
+func TestReadPlanJSONRejectsEscapingLinkTarget(t *testing.T) {
+	dir := t.TempDir()
+	input := `{"source": "` + filepath.ToSlash(dir) + `", "dest": "` + filepath.ToSlash(dir) + `",
+		"operations": [{"op": "link", "path": "a.txt", "target": "../../etc/passwd"}]}`
+
+	if _, err := readPlanJSON(strings.NewReader(input)); err == nil {
+		t.Error("expected error for a link target outside the destination")
+	}
+}
+
+func TestRunApplyRecordsState(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	destDir := filepath.Join(t.TempDir(), "App")
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.setSource(srcDir)
+	var buf bytes.Buffer
+	if err := writePlanJSON(&buf, plan, &syncArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(planPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"apply", planPath}); err != nil {
+		t.Fatalf("apply error = %v", err)
+	}
+
+	// The next sync can use the index and identities, as after a sync
+	state, err := loadState(destDir)
+	if err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if len(state.Files) != 1 || state.Files[0] != "a.txt" {
+		t.Errorf("recorded files = %q, want [a.txt]", state.Files)
+	}
+	if runtime.GOOS != "windows" {
+		if _, ok := state.IDs["a.txt"]; !ok {
+			t.Errorf("recorded identities = %v, want a.txt's", state.IDs)
+		}
+	}
+}
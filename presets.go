@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// presets are curated exclusion sets for common project types, selected
+// with --preset.
+var presets = map[string][]string{
+	"go": {
+		"*.test",
+		"*.prof",
+		"*.out",
+		"go.work.sum",
+	},
+	"node": {
+		"node_modules/",
+		".npm/",
+		".next/",
+		".nuxt/",
+		".cache/",
+		".parcel-cache/",
+		"coverage/",
+		"npm-debug.log*",
+		"yarn-debug.log*",
+		"yarn-error.log*",
+		".pnpm-debug.log*",
+	},
+	"python": {
+		"__pycache__/",
+		"*.py[cod]",
+		"*.egg-info/",
+		".venv/",
+		"venv/",
+		".pytest_cache/",
+		".mypy_cache/",
+		".ruff_cache/",
+		".tox/",
+		".coverage",
+	},
+	"rust": {
+		"target/",
+		"*.rs.bk",
+	},
+	"unity": {
+		"/[Ll]ibrary/",
+		"/[Tt]emp/",
+		"/[Oo]bj/",
+		"/[Bb]uild/",
+		"/[Bb]uilds/",
+		"/[Ll]ogs/",
+		"/[Uu]ser[Ss]ettings/",
+		"/[Mm]emoryCaptures/",
+		"*.csproj",
+		"*.sln",
+		"*.pidb",
+		"*.pdb",
+		"*.mdb",
+	},
+}
+
+// parsePresets splits a --preset argument ("node" or "node,python") and
+// checks each name.
+func parsePresets(arg string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(arg, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := presets[name]; !ok {
+			known := make([]string, 0, len(presets))
+			for k := range presets {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown preset %q (want %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// selectionRules returns the rules added by --preset and --include flags.
+// Presets are checked when parsed, so unknown names are ignored here.
+func selectionRules(presetNames, includes []string) []rule {
+	var rules []rule
+	for _, name := range presetNames {
+		rules = append(rules, newRules("preset:"+name, presets[name]...)...)
+	}
+	for _, pattern := range includes {
+		rules = append(rules, rule{Pattern: pattern, Source: "--include", Include: true})
+	}
+	return rules
+}
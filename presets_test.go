@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePresets(t *testing.T) {
+	names, err := parsePresets("node, python")
+	if err != nil {
+		t.Fatalf("parsePresets() error = %v", err)
+	}
+	if len(names) != 2 || names[0] != "node" || names[1] != "python" {
+		t.Errorf("parsePresets() = %q, want [node python]", names)
+	}
+
+	if _, err := parsePresets("node,cobol"); err == nil {
+		t.Error("expected error for unknown preset")
+	}
+}
+
+func TestSelectionRules(t *testing.T) {
+	rules := append(newRules("default", ".git"), selectionRules([]string{"node", "python"}, []string{"coverage/", ".git"})...)
+
+	tests := []struct {
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{"node_modules", true, true},
+		{"src/__pycache__", true, true},
+		{"app.pyc", false, true},
+		{"src/index.js", false, false},
+		// --include overrides presets but not rift's defaults
+		{"coverage", true, false},
+		{"coverage/lcov.info", false, false},
+		{".git", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.relPath, func(t *testing.T) {
+			if got := shouldExclude(tt.relPath, rules, tt.isDir); got != tt.expected {
+				t.Errorf("shouldExclude(%q, %v) = %v, want %v", tt.relPath, tt.isDir, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExplainIncluded(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "coverage"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := selectionRules([]string{"node"}, []string{"coverage/"})
+	var buf bytes.Buffer
+	if err := explainPath(&buf, srcDir, "coverage", rules); err != nil {
+		t.Fatal(err)
+	}
+	if want := "coverage: included by \"coverage/\" (--include)\n"; buf.String() != want {
+		t.Errorf("explainPath() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestIncludeInsideExcludedDir(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, name := range []string{"node_modules/keep/a.js", "node_modules/drop/b.js", "index.js"} {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules := append(loadRules(srcDir, "", nil), selectionRules([]string{"node"}, []string{"node_modules/keep/"})...)
+	var buf bytes.Buffer
+	if err := listFiles(&buf, srcDir, rules, false); err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if want := "index.js\nnode_modules/keep/a.js\n"; buf.String() != want {
+		t.Errorf("listFiles() = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := explainPath(&buf, srcDir, "node_modules/keep/a.js", rules); err != nil {
+		t.Fatal(err)
+	}
+	if want := "node_modules/keep/a.js: included by \"node_modules/keep/\" (--include)\n"; buf.String() != want {
+		t.Errorf("explainPath() = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build darwin
+
+package main
+
+import "syscall"
+
+const (
+	prioDarwinProcess = 4
+	prioDarwinBG      = 0x1000
+)
+
+// lowerPriority puts the process in the background state, which lowers its
+// CPU priority and throttles its disk and network IO.
+func lowerPriority() error {
+	return syscall.Setpriority(prioDarwinProcess, 0, prioDarwinBG)
+}
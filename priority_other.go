@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const processModeBackgroundBegin = 0x00100000
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// lowerPriority enters background processing mode, which lowers the
+// process's CPU, IO and memory priority.
+func lowerPriority() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if r, _, err := procSetPriorityClass.Call(uintptr(process), processModeBackgroundBegin); r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the bytes written per second. A
+// single limiter is shared by every copy in a run, so the limit holds for
+// the run as a whole rather than per file. A nil *rateLimiter never waits.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), last: time.Now()}
+}
+
+// wait blocks until n more bytes may be written. The bytes are reserved
+// before sleeping, so concurrent writers queue up behind each other.
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.last = now
+	// Allow at most one second of burst after idling
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// limitedWriter throttles writes to w through a rateLimiter.
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+// rateChunk bounds how much is written per wait, keeping throughput smooth.
+const rateChunk = 32 * 1024
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > rateChunk {
+			chunk = chunk[:rateChunk]
+		}
+		lw.limiter.wait(len(chunk))
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimitedWriterSharedAcrossWriters(t *testing.T) {
+	const rate = 1 << 20 // 1 MiB/s
+	limiter := newRateLimiter(rate)
+
+	// Two concurrent writers of 128 KiB each share the budget, so the pair
+	// needs about a quarter of a second
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			w := &limitedWriter{w: &buf, limiter: limiter}
+			if n, err := w.Write(make([]byte, 128<<10)); err != nil || n != 128<<10 {
+				t.Errorf("Write() = %d, %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("writing 256 KiB at 1 MiB/s took %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestNilRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("a zero rate should mean no limiter")
+	}
+
+	var limiter *rateLimiter
+	start := time.Now()
+	limiter.wait(1 << 30)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("a nil limiter should never wait")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runLog is the intent log of a run in progress: each operation is
+// written to it before it is applied, and the log is removed once the run
+// succeeds. A log left behind means the run died or failed part-way, and
+// says where. A nil *runLog records nothing.
+type runLog struct {
+	file *os.File
+	enc  *json.Encoder
+	sync bool // Flush each record to disk, for --fsync
+}
+
+// runRecord is one line of a run log. The first line describes the run,
+// every later one an operation about to be applied.
+type runRecord struct {
+	Started time.Time `json:"started,omitempty"`
+	Total   int       `json:"total,omitempty"` // Operations in the plan
+	Index   int       `json:"index,omitempty"` // 1-based position of Op in the plan
+	Op      opKind    `json:"op,omitempty"`
+	Path    string    `json:"path,omitempty"`
+}
+
+func runLogPath(dest string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "runs", destKey(dest)+".jsonl"), nil
+}
+
+// startRun opens the run log of plan. The first line is flushed to disk so
+// even a machine that dies mid-run leaves a trace of it; with sync, every
+// other line is too, so the trace says exactly where it stopped.
+func startRun(plan *syncPlan, sync bool) (*runLog, error) {
+	path, err := runLogPath(plan.Dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &runLog{file: file, enc: json.NewEncoder(file), sync: sync}
+	if err := l.enc.Encode(runRecord{Started: time.Now(), Total: len(plan.Ops)}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// record notes that the index'th operation (counting from 0) is about to
+// be applied.
+func (l *runLog) record(index int, op operation) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.enc.Encode(runRecord{Index: index + 1, Op: op.Kind, Path: filepath.ToSlash(op.Path)}); err != nil {
+		return fmt.Errorf("writing run log: %w", err)
+	}
+	if l.sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+func (l *runLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// finishRun removes the run log of dest once nothing is left unfinished.
+func finishRun(dest string) error {
+	path, err := runLogPath(dest)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// interruptedRun describes the run to dest that didn't finish, if any.
+func interruptedRun(dest string) (string, bool) {
+	path, err := runLogPath(dest)
+	if err != nil {
+		return "", false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	// A partial last line is an operation that was never started
+	var header, last runRecord
+	dec := json.NewDecoder(file)
+	if err := dec.Decode(&header); err != nil {
+		return "", false
+	}
+	for {
+		var rec runRecord
+		if dec.Decode(&rec) != nil {
+			break
+		}
+		last = rec
+	}
+
+	started := header.Started.Format(time.DateTime)
+	if last.Index == 0 {
+		return fmt.Sprintf("the sync started %s stopped before changing anything", started), true
+	}
+	return fmt.Sprintf("the sync started %s stopped at %s %s, operation %d of %d", started, last.Op, last.Path, last.Index, header.Total), true
+}
+
+// checkInterrupted reports a run to dest that didn't finish before another
+// one starts. Syncing again finishes it, since the new plan covers what is
+// left; but if the run kept undo data, that would be lost, so the user has
+// to choose between rift undo and resume.
+func checkInterrupted(dest string, resume bool) error {
+	desc, ok := interruptedRun(dest)
+	if !ok {
+		return nil
+	}
+	if !resume {
+		if dir, err := undoDir(dest); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, "journal.json")); err == nil {
+				return fmt.Errorf("%s: %s; run rift undo to roll it back, or sync with --resume to finish it", dest, desc)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warning: %s: %s; finishing it\n", dest, desc)
+	return nil
+}
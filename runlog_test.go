@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterruptedRun(t *testing.T) {
+	destDir := filepath.Join(t.TempDir(), "App")
+	plan := &syncPlan{Dest: destDir, Ops: []operation{
+		{Kind: opCopy, Path: "a.txt"},
+		{Kind: opDelete, Path: filepath.Join("old", "b.txt")},
+		{Kind: opCopy, Path: "c.txt"},
+	}}
+
+	// A run that dies after starting its second operation
+	run, err := startRun(plan, false)
+	if err != nil {
+		t.Fatalf("startRun() error = %v", err)
+	}
+	for i, op := range plan.Ops[:2] {
+		if err := run.record(i, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run.Close()
+
+	desc, ok := interruptedRun(destDir)
+	if !ok || !strings.Contains(desc, "stopped at delete old/b.txt, operation 2 of 3") {
+		t.Errorf("interruptedRun() = %q, %v", desc, ok)
+	}
+	if err := checkInterrupted(destDir, false); err != nil {
+		t.Errorf("checkInterrupted() without undo data error = %v", err)
+	}
+
+	// With undo data, the user has to choose between undo and resume
+	journal, err := openUndoJournal(destDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+	if err := checkInterrupted(destDir, false); err == nil {
+		t.Error("expected an error for an interrupted run that can be undone")
+	}
+	if err := checkInterrupted(destDir, true); err != nil {
+		t.Errorf("checkInterrupted() with resume error = %v", err)
+	}
+
+	// A run that finishes leaves nothing behind
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := executePlan(&syncPlan{Dest: destDir}, syncOptions{}); err != nil {
+		t.Fatalf("executePlan() error = %v", err)
+	}
+	if _, ok := interruptedRun(destDir); ok {
+		t.Error("a finished run should not be reported as interrupted")
+	}
+}
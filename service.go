@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// schedule describes a recurring sync installed as a systemd timer or
+// launchd agent: the sync flags to run from Dir every Every.
+type schedule struct {
+	Name      string
+	Every     time.Duration
+	Dir       string
+	Exe       string
+	SyncFlags []string
+}
+
+// parseScheduleArgs parses "[--every <duration>] [--unit <name>] --
+// <sync flags>". The sync flags are validated up front so a broken
+// schedule is caught at install time rather than on its first run.
+func parseScheduleArgs(command string, args []string) (*schedule, error) {
+	s := &schedule{}
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--every":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--every requires a duration argument")
+			}
+			i++
+			every, err := time.ParseDuration(args[i])
+			if err != nil || every < time.Minute {
+				return nil, fmt.Errorf("--every: invalid interval %q (e.g. 30m, 1h, at least 1m)", args[i])
+			}
+			s.Every = every
+		case "--unit":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--unit requires a name argument")
+			}
+			i++
+			s.Name = args[i]
+		case "--":
+			s.SyncFlags = args[i+1:]
+			i = len(args)
+		default:
+			return nil, fmt.Errorf("unknown flag: %s (sync flags go after --)", args[i])
+		}
+	}
+
+	if len(s.SyncFlags) == 0 {
+		return nil, fmt.Errorf("%s requires sync flags after --, e.g. -- --to /backup", command)
+	}
+	parsed, err := parseSyncArgs(s.SyncFlags)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.help {
+		return nil, fmt.Errorf("--help can't be scheduled")
+	}
+
+	if s.Dir, err = os.Getwd(); err != nil {
+		return nil, fmt.Errorf("getting working directory: %w", err)
+	}
+	if s.Exe, err = os.Executable(); err != nil {
+		return nil, fmt.Errorf("locating rift executable: %w", err)
+	}
+	if s.Name == "" {
+		s.Name = "rift-" + filepath.Base(s.Dir)
+	}
+	s.Name = sanitizeUnitName(s.Name)
+	return s, nil
+}
+
+// scheduleHelp reports whether help was asked for before the sync flags.
+func scheduleHelp(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if arg == "-h" || arg == "--help" {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeUnitName replaces characters that aren't safe in a unit or
+// launchd label with dashes.
+func sanitizeUnitName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+func runInstallService(args []string) error {
+	if scheduleHelp(args) {
+		printUsage()
+		return nil
+	}
+
+	s, err := parseScheduleArgs("install-service", args)
+	if err != nil {
+		return err
+	}
+	if s.Every == 0 {
+		return fmt.Errorf("--every flag is required")
+	}
+
+	dir, err := systemdUserDir()
+	if err != nil {
+		return err
+	}
+	servicePath, timerPath, err := writeSystemdUnits(dir, s)
+	if err != nil {
+		return err
+	}
+	fmt.Println(servicePath)
+	fmt.Println(timerPath)
+
+	for _, args := range [][]string{
+		{"--user", "daemon-reload"},
+		{"--user", "enable", "--now", s.Name + ".timer"},
+	} {
+		if out, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// systemdUserDir returns the directory for user-level units.
+func systemdUserDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// writeSystemdUnits writes a oneshot service running the sync and a timer
+// that starts it every s.Every, beginning that long after boot.
+func writeSystemdUnits(dir string, s *schedule) (string, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+
+	// Both of these go into settings where % starts a specifier
+	workDir, name := strings.ReplaceAll(s.Dir, "%", "%%"), strings.ReplaceAll(s.Name, "%", "%%")
+	words := []string{systemdQuote(s.Exe)}
+	for _, arg := range s.SyncFlags {
+		words = append(words, systemdQuote(arg))
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=rift sync of %s
+
+[Service]
+Type=oneshot
+WorkingDirectory=%s
+ExecStart=%s
+`, workDir, workDir, strings.Join(words, " "))
+
+	seconds := int64(s.Every / time.Second)
+	timer := fmt.Sprintf(`[Unit]
+Description=Run %s every %s
+
+[Timer]
+OnBootSec=%ds
+OnUnitActiveSec=%ds
+
+[Install]
+WantedBy=timers.target
+`, name, s.Every, seconds, seconds)
+
+	servicePath := filepath.Join(dir, s.Name+".service")
+	timerPath := filepath.Join(dir, s.Name+".timer")
+	if err := os.WriteFile(servicePath, []byte(service), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(timerPath, []byte(timer), 0644); err != nil {
+		return "", "", err
+	}
+	return servicePath, timerPath, nil
+}
+
+// systemdQuote quotes a word for ExecStart=, escaping the specifier
+// character % as well.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$")
+	return `"` + r.Replace(s) + `"`
+}
+
+func runInstallAgent(args []string) error {
+	if scheduleHelp(args) {
+		printUsage()
+		return nil
+	}
+
+	s, err := parseScheduleArgs("install-agent", args)
+	if err != nil {
+		return err
+	}
+	if s.Every == 0 {
+		return fmt.Errorf("--every flag is required")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	plistPath, err := writeLaunchdAgent(filepath.Join(home, "Library", "LaunchAgents"), filepath.Join(home, "Library", "Logs", "rift"), s)
+	if err != nil {
+		return err
+	}
+	fmt.Println(plistPath)
+
+	// Reinstalling replaces a loaded agent, which launchd only rereads on load
+	_ = exec.Command("launchctl", "unload", plistPath).Run()
+	if out, err := exec.Command("launchctl", "load", "-w", plistPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl load: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// writeLaunchdAgent writes a launchd agent plist to agentDir that runs the
+// sync at load and every s.Every, logging to logDir. The agent is not kept
+// alive between runs; launchd starts it again at the next interval.
+func writeLaunchdAgent(agentDir, logDir string, s *schedule) (string, error) {
+	if err := os.MkdirAll(agentDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	plistString(&b, "Label", s.Name)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{s.Exe}, s.SyncFlags...) {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	plistString(&b, "WorkingDirectory", s.Dir)
+	fmt.Fprintf(&b, "\t<key>StartInterval</key>\n\t<integer>%d</integer>\n", int64(s.Every/time.Second))
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<false/>\n")
+	plistString(&b, "StandardOutPath", filepath.Join(logDir, s.Name+".log"))
+	plistString(&b, "StandardErrorPath", filepath.Join(logDir, s.Name+".log"))
+	b.WriteString("</dict>\n</plist>\n")
+
+	plistPath := filepath.Join(agentDir, s.Name+".plist")
+	if err := os.WriteFile(plistPath, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+	return plistPath, nil
+}
+
+func plistString(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "\t<key>%s</key>\n\t<string>%s</string>\n", key, xmlEscape(value))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleArgs(t *testing.T) {
+	s, err := parseScheduleArgs("install-service", []string{"--every", "1h", "--unit", "my backup", "--", "--to", "/backup"})
+	if err != nil {
+		t.Fatalf("parseScheduleArgs() error = %v", err)
+	}
+	if s.Every != time.Hour {
+		t.Errorf("Every = %v, want 1h", s.Every)
+	}
+	if s.Name != "my-backup" {
+		t.Errorf("Name = %q, want %q", s.Name, "my-backup")
+	}
+	if len(s.SyncFlags) != 2 || s.SyncFlags[0] != "--to" {
+		t.Errorf("SyncFlags = %q, want [--to /backup]", s.SyncFlags)
+	}
+
+	for _, args := range [][]string{
+		{"--every", "1h"},
+		{"--every", "1h", "--", "--exclude", "x"},
+		{"--every", "soon", "--", "--to", "/backup"},
+		{"--to", "/backup"},
+	} {
+		if _, err := parseScheduleArgs("install-service", args); err == nil {
+			t.Errorf("parseScheduleArgs(%q) should fail", args)
+		}
+	}
+}
+
+func TestWriteSystemdUnits(t *testing.T) {
+	dir := t.TempDir()
+	s := &schedule{
+		Name:      "rift-addon",
+		Every:     90 * time.Minute,
+		Dir:       "/home/me/100% Addon",
+		Exe:       "/usr/local/bin/rift",
+		SyncFlags: []string{"--to", "/games/addons", "--exclude", "*.psd"},
+	}
+
+	servicePath, timerPath, err := writeSystemdUnits(dir, s)
+	if err != nil {
+		t.Fatalf("writeSystemdUnits() error = %v", err)
+	}
+
+	service, err := os.ReadFile(servicePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Description=rift sync of /home/me/100%% Addon\n",
+		"WorkingDirectory=/home/me/100%% Addon\n",
+		"ExecStart=/usr/local/bin/rift --to /games/addons --exclude *.psd\n",
+	} {
+		if !strings.Contains(string(service), want) {
+			t.Errorf("service missing %q:\n%s", want, service)
+		}
+	}
+
+	timer, err := os.ReadFile(timerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(timer), "OnUnitActiveSec=5400s\n") {
+		t.Errorf("timer missing interval:\n%s", timer)
+	}
+	if filepath.Base(timerPath) != "rift-addon.timer" {
+		t.Errorf("timer path = %s, want rift-addon.timer", timerPath)
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/usr/bin/rift", "/usr/bin/rift"},
+		{"My Addon", `"My Addon"`},
+		{"50%", "50%%"},
+		{`a"b`, `"a\"b"`},
+		{"$HOME", `"$$HOME"`},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		if got := systemdQuote(tt.in); got != tt.want {
+			t.Errorf("systemdQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteLaunchdAgent(t *testing.T) {
+	dir := t.TempDir()
+	s := &schedule{
+		Name:      "rift-addon",
+		Every:     time.Hour,
+		Dir:       "/Users/me/Addon",
+		Exe:       "/usr/local/bin/rift",
+		SyncFlags: []string{"--to", "/Volumes/Games", "--exclude", "<tmp>&"},
+	}
+
+	plistPath, err := writeLaunchdAgent(filepath.Join(dir, "LaunchAgents"), filepath.Join(dir, "Logs"), s)
+	if err != nil {
+		t.Fatalf("writeLaunchdAgent() error = %v", err)
+	}
+	if filepath.Base(plistPath) != "rift-addon.plist" {
+		t.Errorf("plist path = %s, want rift-addon.plist", plistPath)
+	}
+
+	data, err := os.ReadFile(plistPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plist := string(data)
+	for _, want := range []string{
+		"<key>Label</key>\n\t<string>rift-addon</string>",
+		"<string>/usr/local/bin/rift</string>\n\t\t<string>--to</string>",
+		"<string>&lt;tmp&gt;&amp;</string>",
+		"<key>WorkingDirectory</key>\n\t<string>/Users/me/Addon</string>",
+		"<key>StartInterval</key>\n\t<integer>3600</integer>",
+		"<string>" + filepath.Join(dir, "Logs", "rift-addon.log") + "</string>",
+	} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("plist missing %q:\n%s", want, plist)
+		}
+	}
+}
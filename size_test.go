@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"512", 512},
+		{"64K", 64 << 10},
+		{"64k", 64 << 10},
+		{"5M", 5 << 20},
+		{"5MB", 5 << 20},
+		{"5MiB", 5 << 20},
+		{"1.5G", 3 << 29},
+		{"2T", 2 << 40},
+		{"0", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.input)
+		if err != nil {
+			t.Errorf("parseSize(%q) error = %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.input, got, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"", "fast", "-5M", "5X"} {
+		if _, err := parseSize(input); err == nil {
+			t.Errorf("parseSize(%q) expected error", input)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	btrfsSuperMagic = 0x9123683e
+	btrfsRootIno    = 256 // Inode number of every subvolume's root directory
+)
+
+// fsSnapshot takes a read-only btrfs snapshot of the subvolume holding
+// srcPath and returns srcPath as seen in the snapshot. When srcPath is the
+// subvolume's root, the snapshot shows up there as an empty directory named
+// after snapshotPrefix, which the walk skips. Cleanup deletes the snapshot.
+func fsSnapshot(srcPath string) (string, func(), error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(srcPath, &fs); err != nil {
+		return "", func() {}, err
+	}
+	if uint32(fs.Type) != btrfsSuperMagic {
+		return "", func() {}, fmt.Errorf("%s is not on a btrfs filesystem", srcPath)
+	}
+
+	// The subvolume's root is the nearest directory with its root inode
+	root := srcPath
+	for {
+		var st syscall.Stat_t
+		if err := syscall.Stat(root, &st); err != nil {
+			return "", func() {}, err
+		}
+		if st.Ino == btrfsRootIno {
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", func() {}, fmt.Errorf("no btrfs subvolume found above %s", srcPath)
+		}
+		root = parent
+	}
+
+	// A snapshot has to be on the same filesystem, and the subvolume
+	// itself is the one place that certainly is
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", func() {}, err
+	}
+	snapshot := filepath.Join(root, snapshotPrefix+hex.EncodeToString(b))
+	if out, err := exec.Command("btrfs", "subvolume", "snapshot", "-r", root, snapshot).CombinedOutput(); err != nil {
+		return "", func() {}, fmt.Errorf("btrfs subvolume snapshot: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	cleanup := func() {
+		if out, err := exec.Command("btrfs", "subvolume", "delete", snapshot).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: removing snapshot %s: %v: %s\n", snapshot, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	rel, err := filepath.Rel(root, srcPath)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return filepath.Join(snapshot, rel), cleanup, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// fsSnapshot is only available on Linux.
+func fsSnapshot(srcPath string) (string, func(), error) {
+	return "", func() {}, fmt.Errorf("--snapshot is only available on Linux (btrfs)")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// targetState is what rift remembers about a destination between runs.
+type targetState struct {
+	Source   string            `json:"source"`
+	Dest     string            `json:"dest"`
+	Excludes []string          `json:"excludes,omitempty"`
+	Presets  []string          `json:"presets,omitempty"`
+	Includes []string          `json:"includes,omitempty"`
+	Link     bool              `json:"link,omitempty"`
+	Options  syncOptions       `json:"options"`
+	LastSync time.Time         `json:"last_sync"`
+	Files    []string          `json:"files,omitempty"`
+	IDs      map[string]fileID `json:"ids,omitempty"` // Source file identities, by path
+	// What Files were transformed with, see transformKey
+	TransformKey string `json:"transform_key,omitempty"`
+}
+
+// stateDir returns where per-destination state is kept: $RIFT_STATE_DIR if
+// set, otherwise a rift folder in the user cache directory.
+func stateDir() (string, error) {
+	if dir := os.Getenv("RIFT_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rift"), nil
+}
+
+// destKey names the files kept about dest after its absolute path.
+func destKey(dest string) string {
+	sum := sha256.Sum256([]byte(dest))
+	return hex.EncodeToString(sum[:8])
+}
+
+// statePath returns the state file for dest.
+func statePath(dest string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "targets", destKey(dest)+".json"), nil
+}
+
+func loadState(dest string) (*targetState, error) {
+	path, err := statePath(dest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state targetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+func removeState(dest string) error {
+	path, err := statePath(dest)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func saveState(state *targetState) error {
+	path, err := statePath(state.Dest)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// listStates returns the recorded destinations of src, sorted by path.
+func listStates(src string) ([]*targetState, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "targets"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var states []*targetState
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "targets", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var state targetState
+		if err := json.Unmarshal(data, &state); err != nil {
+			continue // Skip unreadable state rather than failing status
+		}
+		if state.Source == src {
+			states = append(states, &state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Dest < states[j].Dest })
+	return states, nil
+}
+
+// loadLastSync fills in o from what the last sync to dest recorded: the
+// paths it wrote there, which stand in for walking the destination when
+// looking for orphans unless Rescan is set, the identities of the source
+// files, and the transforms they were written with if those changed. A run
+// that didn't finish since may have written files the index doesn't know
+// about, so the destination is walked after one.
+func (o *syncOptions) loadLastSync(dest string) {
+	state, err := loadState(dest)
+	if err != nil || state.Link {
+		return
+	}
+	if _, interrupted := interruptedRun(dest); !o.Rescan && !interrupted {
+		o.index = state.Files
+	}
+	o.ids = state.IDs
+	if state.TransformKey != transformKey(*o) {
+		o.prev = &syncOptions{Transforms: state.Options.Transforms, EOL: state.Options.EOL}
+	}
+}
+
+// recordPlan saves state as the result of applying plan: the paths it
+// wrote, which index the destination for the next sync, and the identities
+// of the source files, merged with what was recorded for the rest of the
+// tree if the sync was limited to sub-paths.
+func recordPlan(state *targetState, plan *syncPlan) {
+	state.Files, state.IDs = plan.Files, plan.IDs
+	state.TransformKey = transformKey(state.Options)
+	state.mergeSubPaths(state.Options.Paths)
+	recordSync(state)
+}
+
+// recordSync saves the state of a successful run. Failing to do so doesn't
+// fail the sync itself.
+func recordSync(state *targetState) {
+	state.LastSync = time.Now()
+	if err := saveState(state); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: recording sync state: %v\n", err)
+	}
+}
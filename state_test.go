@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadState(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+
+	state := &targetState{Source: "/src/app", Dest: "/backup/app", Excludes: []string{"*.log"}}
+	recordSync(state)
+
+	loaded, err := loadState("/backup/app")
+	if err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if loaded.Source != state.Source || loaded.Dest != state.Dest || len(loaded.Excludes) != 1 {
+		t.Errorf("loadState() = %+v, want %+v", loaded, state)
+	}
+	if loaded.LastSync.IsZero() {
+		t.Error("LastSync should be set by recordSync")
+	}
+
+	if _, err := loadState("/backup/other"); !os.IsNotExist(err) {
+		t.Errorf("loadState() for unknown destination error = %v, want not-exist", err)
+	}
+}
+
+func TestRunRecordsState(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "A"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if err := run([]string{"--to", destDir, "--name", "B", "--link"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	states, err := listStates(srcDir)
+	if err != nil {
+		t.Fatalf("listStates() error = %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("got %d states, want 2", len(states))
+	}
+	if states[0].Dest != filepath.Join(destDir, "A") || states[0].Link {
+		t.Errorf("states[0] = %+v, want copy to A", states[0])
+	}
+	if states[1].Dest != filepath.Join(destDir, "B") || !states[1].Link {
+		t.Errorf("states[1] = %+v, want link to B", states[1])
+	}
+
+	if err := run([]string{"status"}); err != nil {
+		t.Errorf("status error = %v", err)
+	}
+}
+
+func TestRunAfterInterruptedRunFindsOrphans(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "out"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	// A run that copied b.txt and then failed, leaving its run log behind
+	fullDest := filepath.Join(destDir, "out")
+	if err := os.WriteFile(filepath.Join(fullDest, "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log, err := startRun(&syncPlan{Dest: fullDest, Ops: []operation{{Kind: opCopy, Path: "b.txt"}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.record(0, operation{Kind: opCopy, Path: "b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	// b.txt is not in the source, so the next run removes it even though
+	// the last successful sync never recorded it
+	if err := run([]string{"--to", destDir, "--name", "out"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(fullDest, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("b.txt left in the destination after an interrupted run: %v", err)
+	}
+}
+
+func TestStatusIsReadOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("filter uses sh syntax")
+	}
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "filter-ran")
+
+	path := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(path, []byte("same"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "out", "--checksum", "--filter", "touch " + marker + "\n" + testFilter}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	cachePath, err := checksumCachePath(filepath.Join(destDir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{marker, cachePath} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+
+	// A touched file of the same size is compared by content
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"status"}); err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("status ran the --filter plugin")
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Error("status wrote the checksum cache")
+	}
+}
+
+func TestStatusUsesRecordedCase(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "debug.LOG"), []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	// Sync the opposite of the platform's default
+	flag := "--ignore-case"
+	if defaultIgnoreCase {
+		flag = "--match-case"
+	}
+	if err := run([]string{"--to", destDir, "--name", "out", "--exclude", "*.log", flag}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	state, err := loadState(filepath.Join(destDir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Options.IgnoreCase == nil || *state.Options.IgnoreCase == defaultIgnoreCase {
+		t.Fatalf("recorded IgnoreCase = %v, want %v", state.Options.IgnoreCase, !defaultIgnoreCase)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = run([]string{"status"})
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if !strings.Contains(string(out), "pending:   0 changes") {
+		t.Errorf("status output:\n%s\nwant no pending changes", out)
+	}
+	if ignoreCase != defaultIgnoreCase {
+		t.Error("status left the recorded case setting in place")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// syncStats summarises one run. Durations are in seconds so the JSON is
+// easy to graph.
+type syncStats struct {
+	Source        string             `json:"source"`
+	Dest          string             `json:"dest"`
+	StartedAt     time.Time          `json:"started_at"`
+	Duration      float64            `json:"duration_seconds"`
+	Phases        map[string]float64 `json:"phase_seconds"`
+	FilesChecked  int                `json:"files_checked"`
+	FilesCopied   int                `json:"files_copied"`
+	FilesLinked   int                `json:"files_linked"`
+	FilesDeleted  int                `json:"files_deleted"`
+	DirsCreated   int                `json:"dirs_created"`
+	BytesCopied   int64              `json:"bytes_copied"`
+	FilesUnstable []string           `json:"files_unstable,omitempty"`
+	Success       bool               `json:"success"`
+	Errors        []string           `json:"errors"`
+}
+
+// addPhase adds the time elapsed since start to the named phase.
+func (s *syncStats) addPhase(name string, start time.Time) {
+	if s.Phases == nil {
+		s.Phases = make(map[string]float64)
+	}
+	s.Phases[name] += time.Since(start).Seconds()
+}
+
+// summary describes the run in one line.
+func (s syncStats) summary() string {
+	return fmt.Sprintf("%s -> %s: %d files checked, %d copied (%d bytes), %d deleted, %d directories created",
+		s.Source, s.Dest, s.FilesChecked, s.FilesCopied, s.BytesCopied, s.FilesDeleted, s.DirsCreated)
+}
+
+// writeStats finishes stats with the outcome of the run and writes them to
+// path as JSON.
+func writeStats(path string, stats syncStats, runErr error) error {
+	stats.Duration = time.Since(stats.StartedAt).Seconds()
+	stats.Success = runErr == nil
+	stats.Errors = []string{}
+	if runErr != nil {
+		stats.Errors = append(stats.Errors, runErr.Error())
+	}
+	if stats.Phases == nil {
+		stats.Phases = map[string]float64{}
+	}
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunStatsJSON(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(destDir, "App"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "App", "orphan.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+
+	if err := run([]string{"--to", destDir, "--name", "App", "--stats-json", statsPath}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	data, err := os.ReadFile(statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats syncStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("invalid stats JSON: %v", err)
+	}
+
+	if !stats.Success || len(stats.Errors) != 0 {
+		t.Errorf("expected a successful run, got %+v", stats)
+	}
+	if stats.FilesChecked != 1 || stats.FilesCopied != 1 || stats.BytesCopied != 5 || stats.DirsCreated != 1 || stats.FilesDeleted != 1 {
+		t.Errorf("unexpected counts: %+v", stats)
+	}
+	for _, phase := range []string{"plan", "copy", "delete"} {
+		if _, ok := stats.Phases[phase]; !ok {
+			t.Errorf("missing timing for phase %q: %v", phase, stats.Phases)
+		}
+	}
+}
+
+func TestWriteStatsRecordsError(t *testing.T) {
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	if err := writeStats(statsPath, syncStats{}, errors.New("disk full")); err != nil {
+		t.Fatalf("writeStats() error = %v", err)
+	}
+
+	data, err := os.ReadFile(statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats syncStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("invalid stats JSON: %v", err)
+	}
+	if stats.Success || len(stats.Errors) != 1 || stats.Errors[0] != "disk full" {
+		t.Errorf("expected a failed run with one error, got %+v", stats)
+	}
+}
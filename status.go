@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func runStatus(args []string) error {
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+		}
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	states, err := listStates(srcPath)
+	if err != nil {
+		return fmt.Errorf("reading sync state: %w", err)
+	}
+	if len(states) == 0 {
+		fmt.Printf("no syncs recorded for %s\n", srcPath)
+		return nil
+	}
+
+	for i, state := range states {
+		if i > 0 {
+			fmt.Println()
+		}
+		printStatus(srcPath, state)
+	}
+	return nil
+}
+
+func printStatus(srcPath string, state *targetState) {
+	mode := "copy"
+	if state.Link {
+		mode = "link"
+	}
+
+	fmt.Println(state.Dest)
+	fmt.Printf("  last sync: %s (%s, %s ago)\n", state.LastSync.Format(time.DateTime), mode, time.Since(state.LastSync).Round(time.Second))
+
+	// The destination folder itself may have been removed; its parent is
+	// what has to be reachable for the next sync
+	if _, err := os.Stat(filepath.Dir(state.Dest)); err != nil {
+		fmt.Println("  reachable: no")
+		return
+	}
+	fmt.Println("  reachable: yes")
+	if desc, ok := interruptedRun(state.Dest); ok {
+		fmt.Printf("  interrupted: %s\n", desc)
+	}
+
+	if state.Link {
+		if target, ok := readLink(state.Dest); ok && target == srcPath {
+			fmt.Println("  pending:   none (linked)")
+		} else {
+			fmt.Println("  pending:   link is missing")
+		}
+		return
+	}
+
+	// Counting changes doesn't need a consistent snapshot, and status is
+	// read-only: it doesn't run a --filter plugin or update the checksum
+	// cache
+	state.Options.VSS, state.Options.Snapshot = false, false
+	state.Options.Filter, state.Options.readOnly = "", true
+	dir, cleanup, err := sourceDir(srcPath, &state.Options)
+	defer cleanup()
+	if err != nil {
+		fmt.Printf("  pending:   unknown (%v)\n", err)
+		return
+	}
+	state.Options.index, state.Options.ids = state.Files, state.IDs
+	if state.Options.IgnoreCase != nil {
+		ignoreCase = *state.Options.IgnoreCase
+		defer func() { ignoreCase = defaultIgnoreCase }()
+	}
+	plan, err := buildPlan(dir, state.Dest, append(loadRules(dir, state.Options.IgnoreFile, state.Excludes), selectionRules(state.Presets, state.Includes)...), state.Options)
+	if err != nil {
+		fmt.Printf("  pending:   unknown (%v)\n", err)
+		return
+	}
+	fmt.Printf("  pending:   %d changes\n", len(plan.Ops))
+}
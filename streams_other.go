@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// copyStreams does nothing: alternate data streams only exist on Windows.
+func copyStreams(src, dest string) error {
+	return nil
+}
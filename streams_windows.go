@@ -0,0 +1,102 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procFindFirstStreamW = syscall.NewLazyDLL("kernel32.dll").NewProc("FindFirstStreamW")
+	procFindNextStreamW  = syscall.NewLazyDLL("kernel32.dll").NewProc("FindNextStreamW")
+)
+
+// errorInvalidFunction is returned by file systems without streams, such
+// as FAT.
+const errorInvalidFunction = syscall.Errno(1)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	size int64
+	name [syscall.MAX_PATH + 36]uint16
+}
+
+// copyStreams copies the NTFS alternate data streams of src to dest. A
+// destination without streams (FAT, exFAT, many SMB shares) still gets the
+// file's content, so a stream that can't be copied is only worth a warning.
+func copyStreams(src, dest string) error {
+	names, err := altStreams(src)
+	if err != nil {
+		return fmt.Errorf("listing alternate data streams of %s: %w", src, err)
+	}
+	for _, name := range names {
+		if err := copyStream(src+":"+name, dest+":"+name); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: copying stream %s of %s: %v\n", name, src, err)
+		}
+	}
+	return nil
+}
+
+// altStreams returns the names of the alternate data streams of path,
+// without the leading ":" and the ":$DATA" type.
+func altStreams(path string) ([]string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data win32FindStreamData
+	h, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		// No streams at all, or a file system without them
+		if errors.Is(err, syscall.ERROR_HANDLE_EOF) || errors.Is(err, errorInvalidFunction) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+
+	var names []string
+	for {
+		if name := streamName(syscall.UTF16ToString(data.name[:])); name != "" {
+			names = append(names, name)
+		}
+		if r, _, err := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data))); r == 0 {
+			if errors.Is(err, syscall.ERROR_HANDLE_EOF) {
+				return names, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// streamName turns a stream name as FindFirstStreamW reports it, such as
+// ":Zone.Identifier:$DATA", into the name that follows the file name in a
+// path. The main stream, "::$DATA", has an empty name.
+func streamName(raw string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(raw, ":"), ":$DATA")
+}
+
+func copyStream(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamName(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"::$DATA", ""},
+		{":Zone.Identifier:$DATA", "Zone.Identifier"},
+		{":tag:$DATA", "tag"},
+	}
+	for _, tt := range tests {
+		if got := streamName(tt.raw); got != tt.want {
+			t.Errorf("streamName(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestCopyStreams(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	dest := filepath.Join(dir, "dest.txt")
+	for _, path := range []string{src, dest} {
+		if err := os.WriteFile(path, []byte("main"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(src+":tag", []byte("stream"), 0644); err != nil {
+		t.Skipf("the temporary directory has no alternate data streams: %v", err)
+	}
+
+	if err := copyStreams(src, dest); err != nil {
+		t.Fatalf("copyStreams() error = %v", err)
+	}
+	if data, err := os.ReadFile(dest + ":tag"); err != nil || string(data) != "stream" {
+		t.Errorf("dest:tag = %q, %v; want %q", data, err, "stream")
+	}
+}
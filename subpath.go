@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// parseSubPath checks a positional sub-path argument, which must lie inside
+// the synced directory, and returns it cleaned.
+func parseSubPath(arg string) (string, error) {
+	p := filepath.Clean(arg)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("%s: sub-paths must be inside the project", arg)
+	}
+	return p, nil
+}
+
+// wholeTree reports whether paths is empty or includes the synced
+// directory itself, so the sync isn't limited at all.
+func wholeTree(paths []string) bool {
+	for _, p := range paths {
+		if p == "." {
+			return true
+		}
+	}
+	return len(paths) == 0
+}
+
+// underSubPath reports whether relPath is one of paths or inside one. No
+// paths means the whole tree.
+func underSubPath(relPath string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if relPath == p || strings.HasPrefix(relPath, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// leadsToSubPath reports whether the directory relPath is, or contains, one
+// of paths, so a sync limited to them has to look inside it.
+func leadsToSubPath(relPath string, paths []string) bool {
+	if underSubPath(relPath, paths) {
+		return true
+	}
+	for _, p := range paths {
+		if strings.HasPrefix(p, relPath+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitOrphans narrows the orphans found in dest to those inside paths. An
+// orphaned directory above a sub-path stands for the sub-path itself.
+func limitOrphans(dest string, orphans, paths []string) []string {
+	if len(paths) == 0 {
+		return orphans
+	}
+	var limited []string
+	for _, path := range orphans {
+		relPath, err := filepath.Rel(dest, path)
+		if err != nil {
+			continue
+		}
+		if underSubPath(relPath, paths) {
+			limited = append(limited, path)
+			continue
+		}
+		for _, p := range paths {
+			if strings.HasPrefix(p, relPath+string(filepath.Separator)) {
+				limited = append(limited, filepath.Join(dest, p))
+			}
+		}
+	}
+	return limited
+}
+
+// mergeSubPathFiles returns the file list of a destination after a sync
+// limited to paths: the synced files, plus those recorded before that lie
+// outside paths. Parents sort before their contents.
+func mergeSubPathFiles(previous, synced, paths []string) []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, f := range synced {
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	for _, f := range previous {
+		if !seen[f] && !underSubPath(f, paths) {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// mergeSubPaths adjusts s, about to be recorded for a sync limited to
+// paths, so it keeps what the last sync recorded outside them. Without
+// such a record the file list is left empty, so the next sync walks the
+// destination rather than trusting a partial index.
+func (s *targetState) mergeSubPaths(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	last, err := loadState(s.Dest)
+	if err != nil || last.Files == nil {
+		s.Files, s.IDs = nil, nil
+		return
+	}
+	s.Files = mergeSubPathFiles(last.Files, s.Files, paths)
+
+	ids := make(map[string]fileID)
+	for p, id := range last.IDs {
+		if !underSubPath(p, paths) {
+			ids[p] = id
+		}
+	}
+	for p, id := range s.IDs {
+		ids[p] = id
+	}
+	s.IDs = ids
+}
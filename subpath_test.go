@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildPlanSubPaths(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	for _, name := range []string{"a/1.txt", "b/2.txt"} {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a/1.txt", "b/2.txt"} {
+		if err := os.Remove(filepath.Join(srcDir, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a", "3.txt"), []byte("3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := buildPlan(srcDir, destDir, nil, syncOptions{Paths: []string{"a"}})
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	want := []operation{
+		{Kind: opCopy, Path: filepath.Join("a", "3.txt")},
+		{Kind: opDelete, Path: filepath.Join("a", "1.txt")},
+	}
+	if len(plan.Ops) != len(want) {
+		t.Fatalf("plan = %+v, want %+v", plan.Ops, want)
+	}
+	for i := range want {
+		if plan.Ops[i].Kind != want[i].Kind || plan.Ops[i].Path != want[i].Path {
+			t.Errorf("Ops[%d] = %+v, want %+v", i, plan.Ops[i], want[i])
+		}
+	}
+}
+
+func TestParseSubPaths(t *testing.T) {
+	parsed, err := parseSyncArgs([]string{"--to", "/tmp", "src/", "assets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(parsed.opts.Paths, ","); got != "src,assets" {
+		t.Errorf("Paths = %q, want %q", got, "src,assets")
+	}
+
+	parsed, err = parseSyncArgs([]string{"--to", "/tmp", "src", "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.opts.Paths != nil {
+		t.Errorf("\".\" should sync the whole tree, got %q", parsed.opts.Paths)
+	}
+
+	if _, err := parseSyncArgs([]string{"--to", "/tmp", "../elsewhere"}); err == nil {
+		t.Error("expected error for a sub-path outside the project")
+	}
+}
+
+func TestMergeSubPathFiles(t *testing.T) {
+	previous := []string{"a", filepath.Join("a", "old.txt"), "b", filepath.Join("b", "keep.txt")}
+	synced := []string{"a", filepath.Join("a", "new.txt")}
+
+	got := mergeSubPathFiles(previous, synced, []string{"a"})
+	want := []string{"a", filepath.Join("a", "new.txt"), "b", filepath.Join("b", "keep.txt")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("mergeSubPathFiles() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// transformSpec applies the named transform to files matching Pattern
+// (gitignore syntax). Specs run in the order given.
+type transformSpec struct {
+	Pattern string `json:"pattern"`
+	Name    string `json:"name"`
+}
+
+// transform rewrites a file's content while it is copied.
+type transform func(w io.Writer, r io.Reader) error
+
+// parseTransformSpec parses a --transform argument of the form
+// <pattern>=<name>, e.g. "*.lua=strip-debug".
+func parseTransformSpec(s string) (transformSpec, error) {
+	pattern, name, ok := strings.Cut(s, "=")
+	if !ok || pattern == "" || name == "" {
+		return transformSpec{}, fmt.Errorf("invalid transform %q (want <pattern>=<name>)", s)
+	}
+	spec := transformSpec{Pattern: pattern, Name: name}
+	if _, err := spec.transform(); err != nil {
+		return transformSpec{}, err
+	}
+	return spec, nil
+}
+
+// transform returns the function implementing spec.Name.
+func (spec transformSpec) transform() (transform, error) {
+	if command, ok := strings.CutPrefix(spec.Name, "exec:"); ok {
+		return execTransform(command), nil
+	}
+	switch spec.Name {
+	case "strip-debug":
+		return stripDebug, nil
+	case "env":
+		return expandEnv, nil
+	case "minify-json":
+		return minifyJSON, nil
+	}
+	return nil, fmt.Errorf("unknown transform %q (want strip-debug, env, minify-json or exec:<command>)", spec.Name)
+}
+
+// transformsFor returns the transforms that apply to relPath, in order:
+// the matching --transform specs followed by --eol conversion.
+func transformsFor(relPath string, opts syncOptions) []transform {
+	var chain []transform
+	for _, spec := range opts.Transforms {
+		if !matchPattern(filepath.ToSlash(relPath), spec.Pattern, false) {
+			continue
+		}
+		// Specs are validated when parsed
+		if t, err := spec.transform(); err == nil {
+			chain = append(chain, t)
+		}
+	}
+	if opts.EOL != "" {
+		chain = append(chain, convertEOL(opts.EOL))
+	}
+	return chain
+}
+
+// transformKey fingerprints what the transforms of opts write: the specs,
+// --eol and, when the env transform is used, the environment. exec:
+// commands are known by their text only. A sync whose key differs from
+// the last one's rewrites every file either of them transforms.
+func transformKey(opts syncOptions) string {
+	if len(opts.Transforms) == 0 && opts.EOL == "" {
+		return ""
+	}
+	h := sha256.New()
+	env := false
+	for _, spec := range opts.Transforms {
+		fmt.Fprintf(h, "%s=%s\x00", spec.Pattern, spec.Name)
+		env = env || spec.Name == "env"
+	}
+	fmt.Fprintf(h, "eol=%s\x00", opts.EOL)
+	if env {
+		vars := os.Environ()
+		sort.Strings(vars)
+		for _, v := range vars {
+			fmt.Fprintf(h, "%s\x00", v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// copyTransformed copies r to w through chain. Each transform runs in its
+// own goroutine, connected to the next by a pipe, so files are streamed
+// rather than held in memory (except by transforms that need the whole
+// file).
+func copyTransformed(w io.Writer, r io.Reader, chain []transform) error {
+	if len(chain) == 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	errs := make(chan error, len(chain)-1)
+	for _, t := range chain[:len(chain)-1] {
+		pr, pw := io.Pipe()
+		go func(t transform, r io.Reader) {
+			err := t(pw, r)
+			pw.CloseWithError(err)
+			closeStage(r)
+			errs <- err
+		}(t, r)
+		r = pr
+	}
+
+	err := chain[len(chain)-1](w, r)
+	closeStage(r)
+	for range chain[:len(chain)-1] {
+		if serr := <-errs; err == nil && serr != nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// closeStage closes the pipe a stage reads from once it returns, so the
+// stage before it can't block writing output nobody will read.
+func closeStage(r io.Reader) {
+	if pr, ok := r.(*io.PipeReader); ok {
+		pr.CloseWithError(io.ErrClosedPipe)
+	}
+}
+
+// stripDebug removes every block from a line containing @debug@ through
+// the next line containing @end-debug@, in any comment style (--@debug@,
+// //@debug@, #@debug@).
+func stripDebug(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	inDebug := false
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case !inDebug && strings.Contains(line, "@debug@"):
+				inDebug = !strings.Contains(line, "@end-debug@")
+			case inDebug:
+				inDebug = !strings.Contains(line, "@end-debug@")
+			default:
+				if _, werr := io.WriteString(w, line); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${NAME} with the value of environment variable NAME.
+// References to unset variables are left as they are.
+func expandEnv(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			line = envRef.ReplaceAllStringFunc(line, func(ref string) string {
+				if value, ok := os.LookupEnv(ref[2 : len(ref)-1]); ok {
+					return value
+				}
+				return ref
+			})
+			if _, werr := io.WriteString(w, line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// minifyJSON removes insignificant whitespace from a JSON document.
+func minifyJSON(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return fmt.Errorf("minify-json: %w", err)
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// execTransform pipes content through command, run by the shell.
+func execTransform(command string) transform {
+	return func(w io.Writer, r io.Reader) error {
+		cmd := shellCommand(command)
+		cmd.Stdin = r
+		cmd.Stdout = w
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("transform %q: %w", command, err)
+		}
+		return nil
+	}
+}
+
+// sniffLen is how much of a file is checked for NUL bytes to tell binary
+// files from text, as git does.
+const sniffLen = 8000
+
+// looksBinary reports whether the start of a file contains a NUL byte.
+func looksBinary(head []byte) bool {
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// isBinaryFile sniffs the start of the file at path.
+func isBinaryFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return looksBinary(head[:n]), nil
+}
+
+// convertEOL returns a transform that rewrites the line endings of text
+// files to eol ("lf", "crlf", or "native" for this platform's). Files with
+// a NUL byte near the start are treated as binary and copied unchanged.
+func convertEOL(eol string) transform {
+	ending := "\n"
+	if eol == "crlf" || (eol == "native" && runtime.GOOS == "windows") {
+		ending = "\r\n"
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		br := bufio.NewReaderSize(r, sniffLen)
+		head, err := br.Peek(sniffLen)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return err
+		}
+		if looksBinary(head) {
+			_, err := io.Copy(w, br)
+			return err
+		}
+
+		for {
+			line, err := br.ReadString('\n')
+			if strings.HasSuffix(line, "\n") {
+				line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r") + ending
+			}
+			if _, werr := io.WriteString(w, line); werr != nil {
+				return werr
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStripDebug(t *testing.T) {
+	in := `local a = 1
+--@debug@
+print("debug")
+--@end-debug@
+local b = 2
+// @debug@ inline @end-debug@
+local c = 3`
+	want := "local a = 1\nlocal b = 2\nlocal c = 3"
+
+	var out bytes.Buffer
+	if err := stripDebug(&out, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("stripDebug() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("RIFT_TEST_VERSION", "1.2.0")
+
+	var out bytes.Buffer
+	in := "version = ${RIFT_TEST_VERSION}\nkeep = ${RIFT_TEST_UNSET} $HOME\n"
+	if err := expandEnv(&out, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	want := "version = 1.2.0\nkeep = ${RIFT_TEST_UNSET} $HOME\n"
+	if out.String() != want {
+		t.Errorf("expandEnv() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestMinifyJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := minifyJSON(&out, strings.NewReader("{\n  \"a\": [1, 2],\n  \"b\": \"x y\"\n}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":[1,2],"b":"x y"}`; out.String() != want {
+		t.Errorf("minifyJSON() = %q, want %q", out.String(), want)
+	}
+
+	if err := minifyJSON(io.Discard, strings.NewReader("{broken")); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestCopyTransformedChain(t *testing.T) {
+	upper := func(w io.Writer, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(bytes.ToUpper(data))
+		return err
+	}
+
+	var out bytes.Buffer
+	in := "a\n--@debug@\nb\n--@end-debug@\nc\n"
+	if err := copyTransformed(&out, strings.NewReader(in), []transform{stripDebug, upper}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "A\nC\n"; out.String() != want {
+		t.Errorf("chain output = %q, want %q", out.String(), want)
+	}
+
+	// A failing stage fails the copy without hanging the others
+	failing := func(w io.Writer, r io.Reader) error { return errors.New("boom") }
+	big := strings.NewReader(strings.Repeat("line\n", 100000))
+	if err := copyTransformed(io.Discard, big, []transform{stripDebug, failing, upper}); err == nil || err.Error() != "boom" {
+		t.Errorf("copyTransformed() error = %v, want boom", err)
+	}
+}
+
+func TestParseTransformSpec(t *testing.T) {
+	spec, err := parseTransformSpec("*.lua=strip-debug")
+	if err != nil {
+		t.Fatalf("parseTransformSpec() error = %v", err)
+	}
+	if spec != (transformSpec{Pattern: "*.lua", Name: "strip-debug"}) {
+		t.Errorf("spec = %+v", spec)
+	}
+
+	for _, arg := range []string{"*.lua", "=env", "*.lua=", "*.lua=uppercase"} {
+		if _, err := parseTransformSpec(arg); err == nil {
+			t.Errorf("parseTransformSpec(%q) should fail", arg)
+		}
+	}
+}
+
+func TestTransformsForNestedPattern(t *testing.T) {
+	opts := syncOptions{Transforms: []transformSpec{{Pattern: "src/*.lua", Name: "strip-debug"}}}
+	if got := transformsFor(filepath.Join("src", "a.lua"), opts); len(got) != 1 {
+		t.Errorf("transformsFor(src/a.lua) = %d transforms, want 1", len(got))
+	}
+	if got := transformsFor("a.lua", opts); len(got) != 0 {
+		t.Errorf("transformsFor(a.lua) = %d transforms, want 0", len(got))
+	}
+}
+
+func TestSyncTransforms(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "Core.lua"), []byte("a\n--@debug@\nb\n--@end-debug@\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "notes.txt"), []byte("--@debug@\nkept\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := syncOptions{Transforms: []transformSpec{{Pattern: "*.lua", Name: "strip-debug"}}}
+	if runtime.GOOS != "windows" {
+		opts.Transforms = append(opts.Transforms, transformSpec{Pattern: "*.lua", Name: "exec:tr a-z A-Z"})
+	}
+	if _, err := syncTree(srcDir, destDir, nil, opts); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "Core.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a\n"
+	if runtime.GOOS != "windows" {
+		want = "A\n"
+	}
+	if string(got) != want {
+		t.Errorf("Core.lua = %q, want %q", got, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(destDir, "notes.txt")); string(got) != "--@debug@\nkept\n" {
+		t.Errorf("notes.txt should not be transformed, got %q", got)
+	}
+
+	// The transformed copy is smaller than its source but unchanged
+	plan, err := buildPlan(srcDir, destDir, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Ops) != 0 {
+		t.Errorf("second plan has operations %+v, want none", plan.Ops)
+	}
+}
+
+func TestConvertEOL(t *testing.T) {
+	tests := []struct {
+		eol, in, want string
+	}{
+		{"lf", "a\r\nb\r\nc", "a\nb\nc"},
+		{"lf", "a\nb\n", "a\nb\n"},
+		{"crlf", "a\nb\r\nc\n", "a\r\nb\r\nc\r\n"},
+		{"crlf", "lone\rcr\n", "lone\rcr\r\n"},
+		// Binary content is copied as-is
+		{"crlf", "bin\x00ary\n", "bin\x00ary\n"},
+		{"lf", "bin\x00ary\r\n", "bin\x00ary\r\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := convertEOL(tt.eol)(&out, strings.NewReader(tt.in)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("convertEOL(%s)(%q) = %q, want %q", tt.eol, tt.in, out.String(), tt.want)
+		}
+	}
+}
+
+func TestRunEOLFlag(t *testing.T) {
+	if err := run([]string{"--to", "/tmp", "--eol", "cr"}); err == nil {
+		t.Error("expected error for unknown --eol value")
+	}
+}
+
+func TestSyncOnlyText(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "main.lua"), []byte("print('hi')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "icon.blp"), []byte("BLP2\x00\x01\x02"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "empty.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for only, want := range map[string][]string{
+		"text":   {"empty.txt", "main.lua"},
+		"binary": {"icon.blp"},
+	} {
+		plan, err := buildPlan(srcDir, destDir, nil, syncOptions{Only: only})
+		if err != nil {
+			t.Fatalf("buildPlan(%s) error = %v", only, err)
+		}
+		if len(plan.Files) != len(want) {
+			t.Fatalf("--only-%s files = %v, want %v", only, plan.Files, want)
+		}
+		for i, name := range want {
+			if plan.Files[i] != name {
+				t.Errorf("--only-%s Files[%d] = %q, want %q", only, i, plan.Files[i], name)
+			}
+		}
+	}
+}
+
+func TestRunRewritesWhenTransformsChange(t *testing.T) {
+	t.Setenv("RIFT_STATE_DIR", t.TempDir())
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "app.env"), []byte("mode=${RIFT_TRANSFORM_MODE}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(origDir) }()
+	out := filepath.Join(destDir, "out", "app.env")
+
+	steps := []struct {
+		args []string
+		mode string
+		want string
+	}{
+		{nil, "aaaa", "mode=${RIFT_TRANSFORM_MODE}\n"},
+		// Adding a transform rewrites the file though the source is unchanged
+		{[]string{"--transform", "*.env=env"}, "aaaa", "mode=aaaa\n"},
+		// So does changing a value it reads, even to one of the same length
+		{[]string{"--transform", "*.env=env"}, "bbbb", "mode=bbbb\n"},
+		// And dropping it
+		{nil, "bbbb", "mode=${RIFT_TRANSFORM_MODE}\n"},
+	}
+	for i, step := range steps {
+		t.Setenv("RIFT_TRANSFORM_MODE", step.mode)
+		if err := run(append([]string{"--to", destDir, "--name", "out"}, step.args...)); err != nil {
+			t.Fatalf("step %d: run() error = %v", i, err)
+		}
+		if got, err := os.ReadFile(out); err != nil || string(got) != step.want {
+			t.Errorf("step %d: app.env = %q, %v; want %q", i, got, err, step.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// uncachedMinSize is the smallest file --direct-io copies past the page
+// cache. Smaller files aren't worth the extra flushing.
+const uncachedMinSize = 8 << 20
+
+// uncachedWriter drops what a copy has read and written from the page
+// cache every uncachedMinSize bytes, so copying a huge file doesn't evict
+// everything else from memory.
+type uncachedWriter struct {
+	w         io.Writer
+	src, dest *os.File
+	pending   int
+}
+
+func (u *uncachedWriter) Write(p []byte) (int, error) {
+	n, err := u.w.Write(p)
+	u.pending += n
+	if err == nil && u.pending >= uncachedMinSize {
+		err = u.flush()
+	}
+	return n, err
+}
+
+// flush writes out the destination's dirty pages, which can't be dropped
+// before they are on disk, and drops both files from the cache.
+func (u *uncachedWriter) flush() error {
+	u.pending = 0
+	if err := u.dest.Sync(); err != nil {
+		return err
+	}
+	if err := dropCache(u.dest); err != nil {
+		return err
+	}
+	return dropCache(u.src)
+}
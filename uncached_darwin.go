@@ -0,0 +1,23 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// setNoCache turns off caching of f's data (F_NOCACHE).
+func setNoCache(f *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_NOCACHE, 1)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dropCache does nothing on macOS, where setNoCache keeps the data out of
+// the cache in the first place.
+func dropCache(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build linux && !386 && !arm && !mips && !mipsle
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadvDontNeed = 4 // POSIX_FADV_DONTNEED
+
+// setNoCache does nothing on Linux, where O_DIRECT would need aligned
+// buffers; dropCache evicts the pages instead.
+func setNoCache(f *os.File) error {
+	return nil
+}
+
+// dropCache evicts the clean cached pages of f.
+func dropCache(f *os.File) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !(linux && !386 && !arm && !mips && !mipsle)
+
+package main
+
+import "os"
+
+// setNoCache does nothing on platforms without a way to bypass the page
+// cache that works for unaligned IO.
+func setNoCache(f *os.File) error {
+	return nil
+}
+
+// dropCache does nothing on platforms without a way to evict cached pages.
+func dropCache(f *os.File) error {
+	return nil
+}
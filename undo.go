@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// undoJournal keeps a copy of everything a run overwrites or deletes at
+// the destination and notes everything it creates, so rift undo can put
+// the destination back the way it was. Only the last run is kept. Entries
+// are appended as they are made, so a run that died part-way can still be
+// undone. A nil *undoJournal keeps nothing.
+type undoJournal struct {
+	dir     string // Where the journal and the saved copies live
+	dest    string
+	seen    map[string]bool
+	entries int
+	file    *os.File // entries.jsonl
+	enc     *json.Encoder
+}
+
+// undoFile is the journal as read back: journal.json holds the header,
+// entries.jsonl one undoEntry per line.
+type undoFile struct {
+	Dest    string       `json:"dest"`
+	State   *targetState `json:"state,omitempty"` // What rift remembered before the run
+	Entries []undoEntry  `json:"-"`
+}
+
+// undoEntry is one destination path the run changed.
+type undoEntry struct {
+	Path    string `json:"path"`              // Relative to the destination
+	Created bool   `json:"created,omitempty"` // Path didn't exist before the run
+	Saved   string `json:"saved,omitempty"`   // Copy of what was there, relative to the journal
+}
+
+// undoDir returns where the undo journal for dest is kept, keyed like its
+// state file.
+func undoDir(dest string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "undo", destKey(dest)), nil
+}
+
+// openUndoJournal starts a journal for a run to dest, replacing the one of
+// the previous run, or returns nil if enabled is false.
+func openUndoJournal(dest string, enabled bool) (*undoJournal, error) {
+	if !enabled {
+		return nil, nil
+	}
+	dir, err := undoDir(dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return nil, err
+	}
+
+	header := undoFile{Dest: dest}
+	if state, err := loadState(dest); err == nil {
+		header.State = state
+	}
+	data, err := json.MarshalIndent(header, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "journal.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(dir, "entries.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	return &undoJournal{dir: dir, dest: dest, seen: make(map[string]bool), file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// save records relPath before the run changes it: a copy of the file or
+// directory there, or that there was nothing. Only the first change to a
+// path is recorded.
+func (j *undoJournal) save(relPath string) error {
+	if j == nil || j.seen[relPath] {
+		return nil
+	}
+	j.seen[relPath] = true
+
+	path := filepath.Join(j.dest, relPath)
+	entry := undoEntry{Path: filepath.ToSlash(relPath)}
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		entry.Created = true
+	} else if err != nil {
+		return err
+	} else {
+		entry.Saved = "files/" + strconv.Itoa(j.entries)
+		if err := copyTree(path, filepath.Join(j.dir, filepath.FromSlash(entry.Saved))); err != nil {
+			return fmt.Errorf("saving %s for undo: %w", path, err)
+		}
+	}
+	j.entries++
+
+	// The entry has to be on disk before the change it undoes is made
+	if err := j.enc.Encode(entry); err != nil {
+		return fmt.Errorf("writing undo journal: %w", err)
+	}
+	return j.file.Sync()
+}
+
+func (j *undoJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// readUndoJournal reads the journal kept in dir.
+func readUndoJournal(dir string) (*undoFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		return nil, err
+	}
+	var journal undoFile
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("reading undo journal: %w", err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "entries.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	for {
+		var entry undoEntry
+		if err := dec.Decode(&entry); err != nil {
+			// A run that died mid-write leaves a partial last line; its
+			// change was never made
+			break
+		}
+		entry.Path = filepath.FromSlash(entry.Path)
+		journal.Entries = append(journal.Entries, entry)
+	}
+	return &journal, nil
+}
+
+// copyTree copies the file, symlink or directory at src to dest, keeping
+// modes and modification times.
+func copyTree(src, dest string) error {
+	var dirs []string
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, path)
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return copyFile(path, target, nil, nil, syncOptions{})
+	})
+	if err != nil {
+		return err
+	}
+
+	// Filling a directory changes its time, so set them last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, dirs[i])
+		if err := os.Chtimes(filepath.Join(dest, rel), info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runUndo(args []string) error {
+	var destPath string
+	var projectName string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--to":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--to requires a path argument")
+			}
+			i++
+			destPath = args[i]
+		case "--name":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--name requires a name argument")
+			}
+			i++
+			projectName = args[i]
+		case "-h", "--help":
+			printUsage()
+			return nil
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("unknown flag: %s", args[i])
+			}
+		}
+	}
+
+	if destPath == "" {
+		return fmt.Errorf("--to flag is required")
+	}
+
+	srcPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+	if projectName == "" {
+		projectName = filepath.Base(srcPath)
+	}
+	fullDest, err := filepath.Abs(filepath.Join(destPath, projectName))
+	if err != nil {
+		return err
+	}
+	return undoLastRun(fullDest)
+}
+
+// undoLastRun puts dest back the way it was before the last journaled run,
+// along with what rift remembered about it, and discards the journal.
+func undoLastRun(dest string) error {
+	dir, err := undoDir(dest)
+	if err != nil {
+		return err
+	}
+	journal, err := readUndoJournal(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("nothing to undo for %s (sync with --keep-undo to make runs undoable)", dest)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Later entries may sit inside earlier ones, so go backwards
+	for i := len(journal.Entries) - 1; i >= 0; i-- {
+		entry := journal.Entries[i]
+		if !filepath.IsLocal(entry.Path) {
+			return fmt.Errorf("undo journal path %q is outside the destination", entry.Path)
+		}
+		path := filepath.Join(dest, entry.Path)
+		info, err := os.Lstat(path)
+		switch {
+		case err == nil && info.IsDir() && entry.Created:
+			// Keep a created directory that now holds files rift didn't put there
+			_ = os.Remove(path)
+			continue
+		case err == nil:
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+		if entry.Saved != "" {
+			logf(levelInfo, "restore %s", filepath.ToSlash(entry.Path))
+			if err := copyTree(filepath.Join(dir, filepath.FromSlash(entry.Saved)), path); err != nil {
+				return fmt.Errorf("restoring %s: %w", path, err)
+			}
+		} else {
+			logf(levelInfo, "remove %s", filepath.ToSlash(entry.Path))
+		}
+	}
+
+	if journal.State != nil {
+		if err := saveState(journal.State); err != nil {
+			return fmt.Errorf("restoring sync state: %w", err)
+		}
+	} else if err := removeState(dest); err != nil {
+		return err
+	}
+	if err := finishRun(dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndoLastRun(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := filepath.Join(t.TempDir(), "App")
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(destDir, "olddir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "new", filepath.Join("sub", "b.txt"): "b"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := map[string]string{"a.txt": "old", "gone.txt": "gone", filepath.Join("olddir", "x.txt"): "x"}
+	for name, content := range before {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := syncTree(srcDir, destDir, nil, syncOptions{KeepUndo: true}); err != nil {
+		t.Fatalf("syncTree() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "gone.txt")); !os.IsNotExist(err) {
+		t.Fatal("gone.txt should have been deleted by the sync")
+	}
+
+	if err := undoLastRun(destDir); err != nil {
+		t.Fatalf("undoLastRun() error = %v", err)
+	}
+	for name, want := range before {
+		got, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v after undo, want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "sub")); !os.IsNotExist(err) {
+		t.Error("sub/ was created by the sync and should be gone after undo")
+	}
+
+	if err := undoLastRun(destDir); err == nil {
+		t.Error("expected an error undoing twice")
+	}
+}
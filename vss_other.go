@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// shadowCopy is only available on Windows.
+func shadowCopy(srcPath string) (string, func(), error) {
+	return "", func() {}, fmt.Errorf("--vss is only available on Windows")
+}
@@ -0,0 +1,74 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// shadowCopy snapshots the volume holding srcPath with the Volume Shadow
+// Copy Service and returns srcPath as seen in the snapshot, where files
+// other programs hold locked can be read. The snapshot is reached through
+// a temporary directory link, since Go's path handling doesn't accept the
+// shadow copy's device path. Cleanup removes the link and the snapshot.
+func shadowCopy(srcPath string) (string, func(), error) {
+	volume := filepath.VolumeName(srcPath)
+	if len(volume) != 2 || volume[1] != ':' {
+		return "", func() {}, fmt.Errorf("%s is not on a lettered volume", srcPath)
+	}
+
+	// Win32_ShadowCopy.Create works on client editions of Windows, unlike
+	// vssadmin create shadow
+	script := fmt.Sprintf(`$ErrorActionPreference = 'Stop'
+$r = ([WMIClass]'Win32_ShadowCopy').Create('%s\', 'ClientAccessible')
+if ($r.ReturnValue -ne 0) { throw "Win32_ShadowCopy.Create returned $($r.ReturnValue)" }
+$s = Get-WmiObject Win32_ShadowCopy -Filter "ID='$($r.ShadowID)'"
+$s.ID
+$s.DeviceObject`, volume)
+	out, err := powershell(script)
+	if err != nil {
+		return "", func() {}, err
+	}
+	lines := strings.Fields(out)
+	if len(lines) != 2 {
+		return "", func() {}, fmt.Errorf("unexpected output from Win32_ShadowCopy: %q", out)
+	}
+	id, device := lines[0], lines[1]
+	remove := func() {
+		if _, err := powershell(fmt.Sprintf(`Get-WmiObject Win32_ShadowCopy -Filter "ID='%s'" | ForEach-Object { $_.Delete() }`, id)); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: removing shadow copy %s: %v\n", id, err)
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", "rift-vss-")
+	if err != nil {
+		remove()
+		return "", func() {}, err
+	}
+	link := filepath.Join(tmp, "volume")
+	if err := os.Symlink(device+`\`, link); err != nil {
+		_ = os.RemoveAll(tmp)
+		remove()
+		return "", func() {}, fmt.Errorf("linking to the shadow copy: %w", err)
+	}
+	cleanup := func() {
+		_ = os.Remove(link)
+		_ = os.RemoveAll(tmp)
+		remove()
+	}
+	return filepath.Join(link, srcPath[len(volume):]), cleanup, nil
+}
+
+// powershell runs script and returns what it wrote to stdout.
+func powershell(script string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(out), err
+}
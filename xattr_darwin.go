@@ -0,0 +1,110 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// macMetadataAttrs are the extended attributes --mac-metadata copies:
+// Finder flags and label, the resource fork, and Finder tags.
+var macMetadataAttrs = []string{
+	"com.apple.FinderInfo",
+	"com.apple.ResourceFork",
+	"com.apple.metadata:_kMDItemUserTags",
+}
+
+// copyMacMetadata copies the Finder metadata and resource fork of src to
+// dest, removing any dest has that src doesn't.
+func copyMacMetadata(src, dest string) error {
+	for _, name := range macMetadataAttrs {
+		value, err := getxattr(src, name)
+		if errors.Is(err, syscall.ENOATTR) {
+			if err := removexattr(dest, name); err != nil && !errors.Is(err, syscall.ENOATTR) {
+				return fmt.Errorf("removing %s from %s: %w", name, dest, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s of %s: %w", name, src, err)
+		}
+		if err := setxattr(dest, name, value); err != nil {
+			return fmt.Errorf("setting %s on %s: %w", name, dest, err)
+		}
+	}
+	return nil
+}
+
+// stripQuarantine removes the com.apple.quarantine attribute from path,
+// which makes Gatekeeper check the file before it is first opened.
+// (com.apple.provenance is also set by downloads, but the system doesn't
+// let it be removed.)
+func stripQuarantine(path string) error {
+	if err := removexattr(path, "com.apple.quarantine"); err != nil && !errors.Is(err, syscall.ENOATTR) {
+		return fmt.Errorf("removing com.apple.quarantine from %s: %w", path, err)
+	}
+	return nil
+}
+
+func getxattr(path, name string) ([]byte, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ask for the size first, then read the value
+	size, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), 0, 0, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	value := make([]byte, size)
+	if size == 0 {
+		return value, nil
+	}
+	size, _, errno = syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(unsafe.Pointer(&value[0])), size, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return value[:size], nil
+}
+
+func setxattr(path, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var v unsafe.Pointer
+	if len(value) > 0 {
+		v = unsafe.Pointer(&value[0])
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(v), uintptr(len(value)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func removexattr(path, name string) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_REMOVEXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
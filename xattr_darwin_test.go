@@ -0,0 +1,61 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyMacMetadata(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	dest := filepath.Join(dir, "dest.txt")
+	for _, path := range []string{src, dest} {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const tags = "com.apple.metadata:_kMDItemUserTags"
+	if err := setxattr(src, tags, []byte("tags")); err != nil {
+		t.Fatal(err)
+	}
+	// A resource fork src doesn't have is removed
+	if err := setxattr(dest, "com.apple.ResourceFork", []byte("fork")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyMacMetadata(src, dest); err != nil {
+		t.Fatalf("copyMacMetadata() error = %v", err)
+	}
+	if value, err := getxattr(dest, tags); err != nil || string(value) != "tags" {
+		t.Errorf("%s = %q, %v; want %q", tags, value, err, "tags")
+	}
+	if _, err := getxattr(dest, "com.apple.ResourceFork"); !errors.Is(err, syscall.ENOATTR) {
+		t.Errorf("resource fork left on dest: %v", err)
+	}
+}
+
+func TestStripQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.zip")
+	if err := os.WriteFile(path, []byte("zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setxattr(path, "com.apple.quarantine", []byte("0081;00000000;Safari;")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stripQuarantine(path); err != nil {
+		t.Fatalf("stripQuarantine() error = %v", err)
+	}
+	if _, err := getxattr(path, "com.apple.quarantine"); !errors.Is(err, syscall.ENOATTR) {
+		t.Errorf("quarantine left on file: %v", err)
+	}
+	// A file without one is fine too
+	if err := stripQuarantine(path); err != nil {
+		t.Errorf("stripQuarantine() on a clean file error = %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !darwin
+
+package main
+
+// copyMacMetadata does nothing: Finder metadata only exists on macOS.
+func copyMacMetadata(src, dest string) error {
+	return nil
+}
+
+// stripQuarantine does nothing: quarantine attributes only exist on macOS.
+func stripQuarantine(path string) error {
+	return nil
+}